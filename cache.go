@@ -0,0 +1,291 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/league/BackendChallenge/matrix"
+)
+
+const (
+	headerETag        = "ETag"
+	headerIfNoneMatch = "If-None-Match"
+	// header telling whether the result was answered from the cache, HIT or MISS
+	headerCache = "X-Cache"
+)
+
+// cacheDir is where the results are cached, one file per result named by its
+// key
+var cacheDir = filepath.Join(tempDir, "matrix_cache")
+
+// cacheSize bounds the bytes of the cached results, the least recently used
+// ones are evicted beyond it. 0 disables the cache.
+var cacheSize int64 = 1024 * 1024 * 1024 // 1GB
+
+// query params that change the result of an operation, part of the cache key
+var cacheParams = []string{"numeric", "mod", "precision", "rounding", "places"}
+
+// results caches the results of the operations, nil when disabled
+var results *resultCache
+
+// resultCache keeps the results of the operations on disk, keyed by a hash of
+// the operation, its options and the uploaded bytes, and evicts the least
+// recently used ones once they exceed maxSize
+type resultCache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *cacheEntry
+	lru     *list.List               // most recently used first
+	size    int64
+}
+
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+// newResultCache loads the results cached in dir by a previous run, the
+// modification time of a file is the last time it was used
+func newResultCache(dir string, maxSize int64) *resultCache {
+	if maxSize <= 0 {
+		return nil
+	}
+	rc := &resultCache{dir: dir, maxSize: maxSize, entries: make(map[string]*list.Element), lru: list.New()}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Errorf("fail to load the result cache: %v", err)
+		}
+		return rc
+	}
+
+	var files []os.FileInfo
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if strings.HasSuffix(info.Name(), ".tmp") {
+			// left by a result that was being written
+			os.Remove(filepath.Join(dir, info.Name()))
+			continue
+		}
+		files = append(files, info)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, info := range files {
+		rc.add(info.Name(), info.Size())
+	}
+	rc.evict()
+	return rc
+}
+
+// add records a result, as the most recently used
+func (rc *resultCache) add(key string, size int64) {
+	rc.entries[key] = rc.lru.PushFront(&cacheEntry{key: key, size: size})
+	rc.size += size
+}
+
+// evict removes the least recently used results until they fit in maxSize
+func (rc *resultCache) evict() {
+	for rc.size > rc.maxSize {
+		entry := rc.lru.Remove(rc.lru.Back()).(*cacheEntry)
+		delete(rc.entries, entry.key)
+		rc.size -= entry.size
+		if err := os.Remove(rc.path(entry.key)); err != nil {
+			logger.Errorf("fail to evict cached result %s: %v", entry.key, err)
+		}
+	}
+}
+
+func (rc *resultCache) path(key string) string {
+	return filepath.Join(rc.dir, key)
+}
+
+// get opens the result cached under key, and marks it as used
+func (rc *resultCache) get(key string) (*os.File, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	elem, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	file, err := os.Open(rc.path(key))
+	if err != nil {
+		logger.Errorf("fail to open cached result %s: %v", key, err)
+		rc.lru.Remove(elem)
+		delete(rc.entries, key)
+		rc.size -= elem.Value.(*cacheEntry).size
+		return nil, false
+	}
+	rc.lru.MoveToFront(elem)
+	now := time.Now()
+	os.Chtimes(file.Name(), now, now)
+	return file, true
+}
+
+// put moves the result written into the file tmp into the cache under key
+func (rc *resultCache) put(key, tmp string, size int64) error {
+	if size > rc.maxSize {
+		return os.Remove(tmp)
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if err := os.Rename(tmp, rc.path(key)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if elem, ok := rc.entries[key]; ok {
+		// computed twice by concurrent requests
+		rc.lru.Remove(elem)
+		rc.size -= elem.Value.(*cacheEntry).size
+	}
+	rc.add(key, size)
+	rc.evict()
+	return nil
+}
+
+// serve answers the result cached under key if any, or 304 Not Modified when
+// the client already holds it. The ETag is set either way, so that a result
+// computed now can be revalidated later.
+func (rc *resultCache) serve(c echo.Context, key string, format matrix.Format) (bool, error) {
+	if rc == nil {
+		return false, nil
+	}
+	resp := c.Response()
+	etag := `"` + key + `"`
+	resp.Header().Set(headerETag, etag)
+
+	result, ok := rc.get(key)
+	if !ok {
+		resp.Header().Set(headerCache, "MISS")
+		return false, nil
+	}
+	defer result.Close()
+
+	resp.Header().Set(headerCache, "HIT")
+	if etagMatch(c.Request().Header.Get(headerIfNoneMatch), etag) {
+		return true, c.NoContent(http.StatusNotModified)
+	}
+	return true, c.Stream(http.StatusOK, format.ContentType(), result)
+}
+
+// store wraps op so that the result it writes is also cached under key once
+// it succeeded
+func (rc *resultCache) store(key string, op func(w io.Writer) error) func(w io.Writer) error {
+	if rc == nil {
+		return op
+	}
+	return func(w io.Writer) error {
+		if err := os.MkdirAll(rc.dir, 0o700); err != nil {
+			logger.Errorf("fail to create the result cache: %v", err)
+			return op(w)
+		}
+		tmp, err := os.CreateTemp(rc.dir, "result_*.tmp")
+		if err != nil {
+			logger.Errorf("fail to cache result: %v", err)
+			return op(w)
+		}
+		counter := &countingWriter{Writer: tmp}
+		if err = op(io.MultiWriter(w, counter)); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+		if err = tmp.Close(); err == nil {
+			err = rc.put(key, tmp.Name(), counter.n)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+			logger.Errorf("fail to cache result: %v", err)
+		}
+		return nil
+	}
+}
+
+// etagMatch reports whether the If-None-Match header lists etag
+func etagMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// countingWriter counts the bytes written
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// keyHash starts the cache key of the result of the request's operation: a
+// hash of the route, the output format and the query params changing the
+// result, followed by the format and bytes of every upload
+func keyHash(c echo.Context, format matrix.Format) hash.Hash {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", c.Path(), format)
+	for _, name := range cacheParams {
+		fmt.Fprintf(h, "%s=%s\n", name, c.QueryParam(name))
+	}
+	return h
+}
+
+// resultKey is the cache key of the result of the request's operation on the
+// uploads, which must be rewindable
+func resultKey(c echo.Context, format matrix.Format, uploads ...*upload) (string, error) {
+	h := keyHash(c, format)
+	for _, u := range uploads {
+		fmt.Fprintf(h, "%s\n", u.Format)
+		if err := u.hash(h); err != nil {
+			var p *problem
+			if errors.As(err, &p) {
+				return "", p // larger than the upload limit
+			}
+			return "", newProblem(http.StatusBadRequest, codeBadRequest, "fail to read the upload: %v", err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// rewindable reports whether the upload can be read again, a multipart file
+// can while a raw body is streamed
+func (u *upload) rewindable() bool {
+	_, ok := u.Reader.(io.ReadSeeker)
+	return ok
+}
+
+// hash writes the uploaded bytes into h, then rewinds the upload
+func (u *upload) hash(h hash.Hash) error {
+	seeker, ok := u.Reader.(io.ReadSeeker)
+	if !ok {
+		return errors.New("the upload cannot be read twice")
+	}
+	if _, err := io.Copy(h, seeker); err != nil {
+		return err
+	}
+	_, err := seeker.Seek(0, io.SeekStart)
+	return err
+}
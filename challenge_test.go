@@ -3,22 +3,29 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"math/big"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/league/BackendChallenge/matrix"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestHandlers(t *testing.T) {
 	e := echo.New()
 	InitLogger()
+	jobsDir = t.TempDir()
+	defaultCacheSize := cacheSize
+	cacheSize = 0 // the same inputs are sent again and again, see "Result cache"
 	Init(e)
 
 	tests := []struct {
@@ -45,6 +52,29 @@ func TestHandlers(t *testing.T) {
 			wantStatus: http.StatusOK,
 			wantBody:   "1,3\n2,4\n",
 		},
+		{
+			name:       "Transpose valid matrix",
+			endpoint:   "/transpose",
+			input:      "1,2\n3,4",
+			wantStatus: http.StatusOK,
+			wantBody:   "1,3\n2,4\n",
+		},
+
+		// Inverse
+		{
+			name:       "Inverse valid matrix",
+			endpoint:   "/inverse",
+			input:      "1,2\n3,4",
+			wantStatus: http.StatusOK,
+			wantBody:   "-2,1\n3/2,-1/2\n",
+		},
+		{
+			name:       "Inverse singular matrix",
+			endpoint:   "/inverse",
+			input:      "1,2\n2,4",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "matrix is singular",
+		},
 
 		// Flatten测试用例
 		{
@@ -71,6 +101,38 @@ func TestHandlers(t *testing.T) {
 			wantBody:   "not a number",
 		},
 
+		// Determinant
+		{
+			name:       "Determinant of square matrix",
+			endpoint:   "/determinant",
+			input:      "2,-3,1\n2,0,-1\n1,4,5",
+			wantStatus: http.StatusOK,
+			wantBody:   "49\n",
+		},
+		{
+			name:       "Determinant of non square matrix",
+			endpoint:   "/determinant",
+			input:      "1,2,3\n4,5,6",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Not a matrix",
+		},
+
+		// Stats
+		{
+			name:       "Stats as key value rows",
+			endpoint:   "/stats",
+			input:      "1,2\n2,4",
+			wantStatus: http.StatusOK,
+			wantBody:   "rows,2\ncolumns,2\ntrace,5\nrank,1\nnullity,1\nsymmetric,true\n",
+		},
+		{
+			name:       "Stats as json",
+			endpoint:   "/stats?format=json",
+			input:      "1,2\n3,4",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"rows":2,"columns":2,"trace":5,"rank":2,"nullity":0,"symmetric":false}` + "\n",
+		},
+
 		// Multiply测试用例
 		{
 			name:       "Multiply valid numbers",
@@ -194,12 +256,585 @@ func TestHandlers(t *testing.T) {
 		e.ServeHTTP(rec, req)
 
 		assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"code":"TIMEOUT"`)
+	})
+
+	t.Run("Matrix product of two files", func(t *testing.T) {
+		req := newFilesRequest(t, "/matmul", map[string]string{"a": "1,2\n3,4", "b": "5,6\n7,8"})
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "19,22\n43,50\n", rec.Body.String())
+
+		req = newFilesRequest(t, "/matmul", map[string]string{"a": "1,2\n3,4", "b": "1,2,3"})
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "inner dimensions mismatch")
+
+		req = newFilesRequest(t, "/matmul", map[string]string{"a": "1,2\n3,4"})
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "no b file found")
+
+		// b is validated even when a has no row
+		req = newFilesRequest(t, "/matmul?input=json", map[string]string{"a": "[]", "b": `[[1,"x"]]`})
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"code":"NOT_A_NUMBER"`)
+	})
+
+	t.Run("Element-wise operations of two files", func(t *testing.T) {
+		for endpoint, want := range map[string]string{
+			"/add":      "6,8\n10,12\n",
+			"/subtract": "-4,-4\n-4,-4\n",
+			"/hadamard": "5,12\n21,32\n",
+		} {
+			req := newFilesRequest(t, endpoint, map[string]string{"a": "1,2\n3,4", "b": "5,6\n7,8"})
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusOK, rec.Code, endpoint)
+			assert.Equal(t, want, rec.Body.String(), endpoint)
+		}
+
+		req := newFilesRequest(t, "/add", map[string]string{"a": "1,2\n3,4", "b": "5,6"})
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "row number inconsistent")
+	})
+
+	t.Run("Output format negotiation", func(t *testing.T) {
+		tests := []struct {
+			endpoint        string
+			accept          string
+			wantStatus      int
+			wantContentType string
+			wantBody        string
+		}{
+			{endpoint: "/echo", accept: "application/json", wantStatus: http.StatusOK, wantContentType: "application/json", wantBody: "[[1,2],[3,4]]\n"},
+			{endpoint: "/transpose", accept: "text/csv;q=0.5, application/x-ndjson", wantStatus: http.StatusOK, wantContentType: "application/x-ndjson", wantBody: "[1,3]\n[2,4]\n"},
+			{endpoint: "/sum?format=json", accept: "text/csv", wantStatus: http.StatusOK, wantContentType: "application/json", wantBody: "[[10]]\n"},
+			{endpoint: "/flatten?format=tsv", wantStatus: http.StatusOK, wantContentType: "text/tab-separated-values", wantBody: "1\t2\t3\t4\n"},
+			{endpoint: "/multiply", accept: "*/*", wantStatus: http.StatusOK, wantContentType: "text/csv", wantBody: "24\n"},
+			{endpoint: "/echo", accept: "image/png", wantStatus: http.StatusNotAcceptable},
+			{endpoint: "/echo?format=xml", wantStatus: http.StatusBadRequest},
+		}
+		for _, tt := range tests {
+			req := newFilesRequest(t, tt.endpoint, map[string]string{"file": "1,2\n3,4"})
+			if tt.accept != "" {
+				req.Header.Set(echo.HeaderAccept, tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code, tt.endpoint)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, tt.wantContentType, rec.Header().Get(echo.HeaderContentType), tt.endpoint)
+				assert.Equal(t, tt.wantBody, rec.Body.String(), tt.endpoint)
+			}
+		}
+	})
+
+	t.Run("Input formats", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			filename string
+			endpoint string
+			input    string
+		}{
+			{name: "JSON by extension", filename: "m.json", endpoint: "/echo", input: `[[1, 2], ["3", 4]]`},
+			{name: "NDJSON by extension", filename: "m.jsonl", endpoint: "/echo", input: "[1,2]\n[3,4]\n"},
+			{name: "TSV by extension", filename: "m.tsv", endpoint: "/echo", input: "1\t2\n3\t4\n"},
+			{name: "Whitespace text by extension", filename: "m.txt", endpoint: "/echo", input: "% comment\n1   2\n\n 3\t4\n"},
+			{name: "Input param wins over extension", filename: "m.csv", endpoint: "/echo?input=txt", input: "1 2\n3 4"},
+			{name: "MatrixMarket by extension", filename: "m.mtx", endpoint: "/echo", input: "%%MatrixMarket matrix coordinate integer general\n2 2 4\n1 1 1\n1 2 2\n2 1 3\n2 2 4\n"},
+			{name: "MatrixMarket array layout", filename: "m.mtx", endpoint: "/transpose", input: "%%MatrixMarket matrix array integer general\n2 2\n1\n2\n3\n4\n"},
+		}
+		for _, tt := range tests {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, _ := writer.CreateFormFile("file", tt.filename)
+			io.Copy(part, strings.NewReader(tt.input))
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, tt.endpoint, body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusOK, rec.Code, tt.name)
+			assert.Equal(t, "1,2\n3,4\n", rec.Body.String(), tt.name)
+		}
+	})
+
+	t.Run("Raw request body", func(t *testing.T) {
+		tests := []struct {
+			name        string
+			endpoint    string
+			contentType string
+			input       string
+			wantStatus  int
+			wantBody    string
+		}{
+			{name: "CSV body", endpoint: "/echo", contentType: "text/csv", input: "1,2\n3,4", wantStatus: http.StatusOK, wantBody: "1,2\n3,4\n"},
+			{name: "No content type", endpoint: "/sum", input: "1,2\n3,4", wantStatus: http.StatusOK, wantBody: "10\n"},
+			{name: "JSON body", endpoint: "/flatten", contentType: "application/json; charset=utf-8", input: "[[1,2],[3,4]]", wantStatus: http.StatusOK, wantBody: "1,2,3,4\n"},
+			{name: "Transpose body", endpoint: "/transpose", contentType: "text/csv", input: "1,2,3\n4,5,6", wantStatus: http.StatusOK, wantBody: "1,4\n2,5\n3,6\n"},
+			{name: "Input param wins", endpoint: "/echo?input=txt", contentType: "text/csv", input: "1 2\n3 4", wantStatus: http.StatusOK, wantBody: "1,2\n3,4\n"},
+			{name: "Decimal inverse in MatrixMarket", endpoint: "/inverse?format=mtx&numeric=float64", contentType: "text/csv", input: "2,0\n0,4", wantStatus: http.StatusOK, wantBody: "%%MatrixMarket matrix coordinate real general\n2 2 2\n1 1 0.5\n2 2 0.25\n"},
+			{name: "Stats body", endpoint: "/stats", contentType: "text/csv", input: "1,2\n2,1", wantStatus: http.StatusOK, wantBody: "rows,2\ncolumns,2\ntrace,2\nrank,2\nnullity,0\nsymmetric,true\n"},
+			{name: "Empty body", endpoint: "/echo", contentType: "text/csv", wantStatus: http.StatusBadRequest, wantBody: "empty file"},
+			{name: "Unsupported media type", endpoint: "/echo", contentType: "application/pdf", input: "1,2", wantStatus: http.StatusUnsupportedMediaType, wantBody: "unsupported media type"},
+			{name: "Binary operation needs a form", endpoint: "/add", contentType: "text/csv", input: "1,2", wantStatus: http.StatusBadRequest, wantBody: "multipart form files"},
+		}
+		for _, tt := range tests {
+			req := httptest.NewRequest(http.MethodPost, tt.endpoint, strings.NewReader(tt.input))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code, tt.name)
+			assert.Contains(t, rec.Body.String(), tt.wantBody, tt.name)
+		}
+	})
+
+	t.Run("Large raw body over a connection", func(t *testing.T) {
+		// the body is streamed with the cache on, as it is by default
+		results = newResultCache(t.TempDir(), defaultCacheSize)
+		defer func() { results = nil }()
+		server := httptest.NewServer(e)
+		defer server.Close()
+
+		// large enough for the result to be flushed before the body is fully
+		// read, the shape is only found not square once it is streamed
+		var input strings.Builder
+		for i := 0; i < 3000; i++ {
+			input.WriteString(strings.Repeat("123456789,", 19) + "123456789\n")
+		}
+		resp, err := http.Post(server.URL+"/echo?validate=false", "text/csv", strings.NewReader(input.String()))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, strings.HasPrefix(string(body), input.String()))
+		assert.Contains(t, string(body), "\n#error {")
+		assert.Equal(t, "NOT_SQUARE", resp.Trailer.Get("X-Matrix-Status"))
+
+		// the response starts before the end of the body is sent
+		pipe, send := io.Pipe()
+		responses := make(chan *http.Response, 1)
+		go func() {
+			resp, err := http.Post(server.URL+"/echo?validate=false", "text/csv", pipe)
+			if err == nil {
+				responses <- resp
+			}
+		}()
+		_, err = io.WriteString(send, input.String())
+		assert.NoError(t, err)
+		select {
+		case resp := <-responses:
+			send.Close()
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		case <-time.After(5 * time.Second):
+			send.CloseWithError(io.ErrUnexpectedEOF)
+			t.Error("the response waited for the whole body")
+		}
+	})
+
+	t.Run("Validate before responding", func(t *testing.T) {
+		// the ragged row comes after the first rows are flushed
+		var input strings.Builder
+		for i := 0; i < 1500; i++ {
+			input.WriteString("1,2\n")
+		}
+		input.WriteString("1,2,3\n")
+		tests := []struct {
+			name        string
+			endpoint    string
+			accept      string
+			wantStatus  int
+			wantBody    string
+			wantTrailer string
+		}{
+			{name: "Validated by default", endpoint: "/flatten", wantStatus: http.StatusBadRequest, wantBody: `"code":"RAGGED_ROW"`},
+			{name: "Streamed on demand", endpoint: "/flatten?validate=false", wantStatus: http.StatusOK, wantBody: "\n#error {", wantTrailer: "RAGGED_ROW"},
+			{name: "Streamed as NDJSON", endpoint: "/flatten?validate=false", accept: "application/x-ndjson", wantStatus: http.StatusOK, wantBody: `{"error":{`, wantTrailer: "RAGGED_ROW"},
+			{name: "Invalid validate", endpoint: "/flatten?validate=maybe", wantStatus: http.StatusBadRequest, wantBody: "invalid validate"},
+		}
+		for _, tt := range tests {
+			req := httptest.NewRequest(http.MethodPost, tt.endpoint, strings.NewReader(input.String()))
+			req.Header.Set("Content-Type", "text/csv")
+			req.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code, tt.name)
+			assert.Contains(t, rec.Body.String(), tt.wantBody, tt.name)
+			assert.Equal(t, tt.wantTrailer, rec.Result().Trailer.Get("X-Matrix-Status"), tt.name)
+		}
+
+		// a streamed result that succeeds ends with an OK trailer
+		req := httptest.NewRequest(http.MethodPost, "/sum?validate=false", strings.NewReader("1,2\n3,4"))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, "10\n", rec.Body.String())
+		assert.Equal(t, "OK", rec.Result().Trailer.Get("X-Matrix-Status"))
+
+		// the marker follows the last complete row, not part of a row
+		defer func(size int) { writeBuffer = size }(writeBuffer)
+		writeBuffer = 7
+		req = httptest.NewRequest(http.MethodPost, "/echo?validate=false", strings.NewReader("1,2,3\n4,5,6\n7,8"))
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, strings.HasPrefix(rec.Body.String(), "1,2,3\n#error {"), rec.Body.String())
+		assert.Equal(t, "RAGGED_ROW", rec.Result().Trailer.Get("X-Matrix-Status"))
+	})
+
+	t.Run("Numeric modes", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			endpoint   string
+			input      string
+			wantStatus int
+			wantBody   string
+		}{
+			{name: "Decimal sum", endpoint: "/sum?numeric=decimal&places=2", input: "1.10,2.205\n3,0.1", wantStatus: http.StatusOK, wantBody: "6.40\n"},
+			{name: "Rational product", endpoint: "/multiply?numeric=rational", input: "2/3,3/4\n1,1", wantStatus: http.StatusOK, wantBody: "1/2\n"},
+			{name: "Rounding", endpoint: "/sum?numeric=rational&places=1&rounding=floor", input: "1/4,0\n0,0", wantStatus: http.StatusOK, wantBody: "0.2\n"},
+			{name: "Stats trace", endpoint: "/stats?numeric=float64", input: "0.5,1\n1,0.25", wantStatus: http.StatusOK, wantBody: "trace,0.75\n"},
+			{name: "Int rejects decimals", endpoint: "/sum", input: "1.5,2\n3,4", wantStatus: http.StatusBadRequest, wantBody: "1.5 is not a number"},
+			{name: "Unknown mode", endpoint: "/sum?numeric=complex", input: "1,2\n3,4", wantStatus: http.StatusBadRequest, wantBody: "unsupported numeric mode"},
+			{name: "Invalid places", endpoint: "/sum?places=-1", input: "1,2\n3,4", wantStatus: http.StatusBadRequest, wantBody: "invalid places"},
+			{name: "Invalid precision", endpoint: "/sum?numeric=decimal&precision=0", input: "1,2\n3,4", wantStatus: http.StatusBadRequest, wantBody: "invalid precision"},
+			{name: "Product modulo n", endpoint: "/multiply?mod=1000000007", input: "123456789,987654321\n2,1000000006", wantStatus: http.StatusOK, wantBody: "481786289\n"},
+			{name: "Determinant modulo n", endpoint: "/determinant?mod=7", input: "4,3\n6,3", wantStatus: http.StatusOK, wantBody: "1\n"},
+			{name: "Inverse singular modulo n", endpoint: "/inverse?mod=4", input: "2,0\n0,1", wantStatus: http.StatusUnprocessableEntity, wantBody: "singular modulo 4"},
+			{name: "Invalid mod", endpoint: "/sum?mod=0", input: "1,2\n3,4", wantStatus: http.StatusBadRequest, wantBody: "invalid mod"},
+			{name: "Mod outside the int mode", endpoint: "/sum?mod=7&numeric=rational", input: "1,2\n3,4", wantStatus: http.StatusBadRequest, wantBody: "mod requires the int numeric mode"},
+		}
+		for _, tt := range tests {
+			req := httptest.NewRequest(http.MethodPost, tt.endpoint, strings.NewReader(tt.input))
+			req.Header.Set("Content-Type", "text/csv")
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code, tt.name)
+			assert.Contains(t, rec.Body.String(), tt.wantBody, tt.name)
+		}
+	})
+
+	t.Run("Problem details", func(t *testing.T) {
+		tests := []struct {
+			name        string
+			endpoint    string
+			contentType string
+			input       string
+			want        problem
+		}{
+			{name: "Not a number", endpoint: "/sum", contentType: "text/csv", input: "1,2\n3,x", want: problem{Status: http.StatusBadRequest, Code: "NOT_A_NUMBER", Row: 2, Column: 2, Token: "x"}},
+			{name: "Ragged row", endpoint: "/flatten", contentType: "text/csv", input: "1,2\n3,4,5", want: problem{Status: http.StatusBadRequest, Code: "RAGGED_ROW", Row: 2, Column: 3, Token: "5"}},
+			{name: "Not square", endpoint: "/echo", contentType: "text/csv", input: "1,2", want: problem{Status: http.StatusBadRequest, Code: "NOT_SQUARE"}},
+			{name: "Singular", endpoint: "/inverse", contentType: "text/csv", input: "1,2\n2,4", want: problem{Status: http.StatusUnprocessableEntity, Code: "SINGULAR"}},
+			{name: "Empty file", endpoint: "/sum", contentType: "text/csv", want: problem{Status: http.StatusBadRequest, Code: "EMPTY_FILE"}},
+			{name: "Unsupported type", endpoint: "/sum", contentType: "application/pdf", input: "1", want: problem{Status: http.StatusUnsupportedMediaType, Code: "UNSUPPORTED_TYPE"}},
+			{name: "Invalid parameter", endpoint: "/sum?places=x", contentType: "text/csv", input: "1", want: problem{Status: http.StatusBadRequest, Code: "INVALID_PARAMETER"}},
+			{name: "Unknown route", endpoint: "/nothing", contentType: "text/csv", input: "1", want: problem{Status: http.StatusNotFound, Code: "NOT_FOUND"}},
+			{name: "Stats in MatrixMarket", endpoint: "/stats?format=mtx", contentType: "text/csv", input: "1", want: problem{Status: http.StatusNotAcceptable, Code: "NOT_ACCEPTABLE"}},
+			{name: "Fractions in MatrixMarket", endpoint: "/inverse?format=mtx", contentType: "text/csv", input: "2,0\n0,4", want: problem{Status: http.StatusNotAcceptable, Code: "NOT_ACCEPTABLE"}},
+			{name: "Rational job in MatrixMarket", endpoint: "/jobs?operation=sum&numeric=rational&format=mtx", contentType: "text/csv", input: "1", want: problem{Status: http.StatusNotAcceptable, Code: "NOT_ACCEPTABLE"}},
+		}
+		for _, tt := range tests {
+			req := httptest.NewRequest(http.MethodPost, tt.endpoint, strings.NewReader(tt.input))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.want.Status, rec.Code, tt.name)
+			assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"), tt.name)
+			var got problem
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got), tt.name)
+			assert.Equal(t, tt.want.Status, got.Status, tt.name)
+			assert.Equal(t, http.StatusText(tt.want.Status), got.Title, tt.name)
+			assert.NotEmpty(t, got.Detail, tt.name)
+			assert.Equal(t, tt.want.Code, got.Code, tt.name)
+			assert.Equal(t, tt.want.Row, got.Row, tt.name)
+			assert.Equal(t, tt.want.Column, got.Column, tt.name)
+			assert.Equal(t, tt.want.Token, got.Token, tt.name)
+		}
+	})
+
+	t.Run("Validate report", func(t *testing.T) {
+		req := newFilesRequest(t, "/validate?max_findings=2", map[string]string{"file": "1,2,\n3,x\n\n5"})
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var report matrix.Report
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		assert.False(t, report.Valid)
+		assert.Equal(t, 3, report.Rows)
+		assert.Equal(t, 4, report.Count)
+		assert.True(t, report.Truncated)
+		if assert.Len(t, report.Findings, 2) {
+			assert.Equal(t, matrix.CodeTrailingDelimiter, report.Findings[0].Code)
+			assert.Equal(t, matrix.Finding{Code: matrix.CodeNotNumber, Message: "x is not a number at row 2, column 2", Row: 2, Col: 2, Line: 2, Token: "x"}, report.Findings[1])
+		}
+
+		req = httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader("1,2\n3,4"))
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"valid":true`)
+		assert.Contains(t, rec.Body.String(), `"square":true`)
+
+		req = httptest.NewRequest(http.MethodPost, "/validate?max_findings=0", strings.NewReader("1"))
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Jobs", func(t *testing.T) {
+		// wait for a job to finish, returning its last state
+		waitJob := func(t *testing.T, id string) jobState {
+			var state jobState
+			assert.Eventually(t, func() bool {
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+id, nil))
+				assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+				return state.Status != jobQueued && state.Status != jobRunning
+			}, 5*time.Second, 10*time.Millisecond)
+			return state
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/jobs?operation=sum", strings.NewReader("1,2\n3,4"))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		var state jobState
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+		assert.Equal(t, "sum", state.Operation)
+		assert.Equal(t, "/jobs/"+state.ID, rec.Header().Get(echo.HeaderLocation))
+
+		state = waitJob(t, state.ID)
+		assert.Equal(t, jobSucceeded, state.Status)
+		assert.Equal(t, int64(2), state.Rows)
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+state.ID+"/result", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "10\n", rec.Body.String())
+
+		// a binary operation takes the files a and b
+		req = newFilesRequest(t, "/jobs", map[string]string{"a": "1,2\n3,4", "b": "5,6\n7,8"})
+		req.URL.RawQuery = "operation=matmul"
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusAccepted, rec.Code)
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+		state = waitJob(t, state.ID)
+		assert.Equal(t, jobSucceeded, state.Status)
+		assert.Equal(t, int64(4), state.Rows)
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+state.ID+"/result", nil))
+		assert.Equal(t, "19,22\n43,50\n", rec.Body.String())
+
+		// a failed job answers its error as the result
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs?operation=sum", strings.NewReader("1,2\n3,x")))
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+		state = waitJob(t, state.ID)
+		assert.Equal(t, jobFailed, state.Status)
+		if assert.NotNil(t, state.Error) {
+			assert.Equal(t, string(matrix.CodeNotNumber), state.Error.Code)
+		}
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+state.ID+"/result", nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"row":2`)
+
+		// deleting a job removes it
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/jobs/"+state.ID, nil))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+state.ID, nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		// the events end with the final state once the job is done
+		eventInterval = time.Millisecond
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs?operation=transpose", strings.NewReader("1,2\n3,4")))
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+state.ID+"/events", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, mimeEventStream, rec.Header().Get(echo.HeaderContentType))
+		events := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n\n"), "\n\n")
+		done, ok := strings.CutPrefix(events[len(events)-1], "event: done\ndata: ")
+		if assert.True(t, ok, rec.Body.String()) {
+			assert.NoError(t, json.Unmarshal([]byte(done), &state))
+			assert.Equal(t, jobSucceeded, state.Status)
+			assert.Equal(t, matrix.PhaseMerging, state.Phase)
+			assert.Equal(t, int64(7), state.Size)
+			assert.Equal(t, state.Size, state.Bytes)
+		}
+
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs?operation=nope", strings.NewReader("1")))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "invalid operation")
+	})
+
+	t.Run("Result cache", func(t *testing.T) {
+		results = newResultCache(t.TempDir(), 1024)
+		defer func() { results = nil }()
+
+		// a raw body is streamed to the operation and not cached
+		req := httptest.NewRequest(http.MethodPost, "/sum", strings.NewReader("1,2\n3,4"))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get(headerCache))
+		assert.Empty(t, rec.Header().Get(headerETag))
+		entries, err := os.ReadDir(results.dir)
+		if err == nil {
+			assert.Empty(t, entries)
+		}
+
+		req = newFilesRequest(t, "/sum", map[string]string{"file": "1,2\n3,4"})
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "MISS", rec.Header().Get(headerCache))
+		assert.Equal(t, []string{"Accept"}, rec.Header().Values("Vary"))
+
+		req = newFilesRequest(t, "/sum", map[string]string{"file": "1,2\n3,4"})
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "HIT", rec.Header().Get(headerCache))
+		assert.Equal(t, []string{"Accept"}, rec.Header().Values("Vary"))
+		etag := rec.Header().Get(headerETag)
+		assert.NotEmpty(t, etag)
+		assert.Equal(t, "10\n", rec.Body.String())
+
+		req = newFilesRequest(t, "/sum", map[string]string{"file": "1,2\n3,4"})
+		req.Header.Set(headerIfNoneMatch, etag)
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Empty(t, rec.Body.String())
+
+		// the operation, the options and the bytes are all part of the key
+		for _, target := range []string{"/multiply", "/sum?numeric=rational", "/sum?format=json"} {
+			req = newFilesRequest(t, target, map[string]string{"file": "1,2\n3,4"})
+			rec = httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, "MISS", rec.Header().Get(headerCache), target)
+			assert.NotEqual(t, etag, rec.Header().Get(headerETag), target)
+		}
+
+		// errors are not cached
+		for i := 0; i < 2; i++ {
+			req = newFilesRequest(t, "/sum", map[string]string{"file": "1,x"})
+			rec = httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Empty(t, rec.Header().Get(headerCache))
+			assert.Empty(t, rec.Header().Get(headerETag))
+		}
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		defer func(size int64, cell, digits int) {
+			maxUploadSize, maxCellLength, maxResultDigits = size, cell, digits
+		}(maxUploadSize, maxCellLength, maxResultDigits)
+		maxUploadSize, maxCellLength, maxResultDigits = 16, 5, 3
+
+		// refused from its Content-Length, or once 16 bytes are read
+		for _, length := range []int64{20, -1} {
+			req := httptest.NewRequest(http.MethodPost, "/sum", strings.NewReader("1,2,3,4,5,6,7,8,9,10"))
+			req.ContentLength = length
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, length)
+			assert.Contains(t, rec.Body.String(), `"code":"UPLOAD_TOO_LARGE"`, length)
+		}
+		req := newFilesRequest(t, "/sum", map[string]string{"file": "1,2"})
+		req.ContentLength = -1
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"code":"UPLOAD_TOO_LARGE"`)
+
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/sum", strings.NewReader("1,123456")))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		var p problem
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+		assert.Equal(t, problem{Type: "about:blank", Title: "Request Entity Too Large", Status: http.StatusRequestEntityTooLarge,
+			Detail: "cell of 6 bytes, more than 5 at row 1, column 2", Code: "CELL_TOO_LONG", Row: 1, Column: 2, Token: "123456"}, p)
+
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/multiply", strings.NewReader("99,99")))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"code":"RESULT_TOO_LARGE"`)
+
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/sum", strings.NewReader("99,99")))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "198\n", rec.Body.String())
+	})
+
+	t.Run("Metrics", func(t *testing.T) {
+		metrics = newServerMetrics()
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/transpose", strings.NewReader("1,2\n3,4")))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/sum", strings.NewReader("1,x")))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, mimeMetrics, rec.Header().Get(echo.HeaderContentType))
+		body := rec.Body.String()
+		for _, line := range []string{
+			`matrix_http_requests_total{route="/sum",method="POST",code="400"} 1`,
+			`matrix_http_requests_total{route="/transpose",method="POST",code="200"} 1`,
+			`matrix_http_request_duration_seconds_bucket{route="/transpose",le="+Inf"} 1`,
+			`matrix_http_request_duration_seconds_count{route="/sum"} 1`,
+			`matrix_http_request_bytes_total{route="/transpose"} 7`,
+			`matrix_http_response_bytes_total{route="/transpose"} 8`,
+			`matrix_rows_processed_total{operation="transpose"} 2`,
+			`matrix_temp_bytes_written_total{operation="transpose"} 8`,
+			"matrix_operations_in_flight 0",
+			"matrix_timeouts_total 0",
+		} {
+			assert.Contains(t, body, line+"\n")
+		}
 	})
 
 	t.Run("Invalid file type", func(t *testing.T) {
 		body := &bytes.Buffer{}
 		writer := multipart.NewWriter(body)
-		part, _ := writer.CreateFormFile("file", "test.txt")
+		part, _ := writer.CreateFormFile("file", "test.pdf")
 		io.Copy(part, strings.NewReader("invalid content"))
 		writer.Close()
 
@@ -209,6 +844,353 @@ func TestHandlers(t *testing.T) {
 		e.ServeHTTP(rec, req)
 
 		assert.Equal(t, http.StatusBadRequest, rec.Code)
-		assert.Contains(t, rec.Body.String(), "only csv file supported")
+		assert.Contains(t, rec.Body.String(), "unsupported file type")
+		assert.Contains(t, rec.Body.String(), "csv, tsv, json, ndjson, txt")
+
+		// an unknown ?input= is the client's mistake, for a form as for a body
+		req = newFilesRequest(t, "/echo?input=bogus", map[string]string{"file": "1,2\n3,4"})
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"code":"UNSUPPORTED_TYPE"`)
+	})
+}
+
+func TestJobStore(t *testing.T) {
+	InitLogger()
+	dir := t.TempDir()
+	store := newJobStore(dir)
+
+	// a cancelled job stops with the context of its run
+	j, err := store.create("sum", matrix.CSV)
+	assert.NoError(t, err)
+	started := make(chan struct{})
+	store.start(j, func(ctx context.Context, dst io.Writer) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	<-started
+	assert.Equal(t, jobRunning, j.snapshot().Status)
+	j.cancel()
+	<-j.done
+	assert.Equal(t, jobCancelled, j.snapshot().Status)
+
+	// a panic fails the job only
+	panicked, err := store.create("sum", matrix.CSV)
+	assert.NoError(t, err)
+	store.start(panicked, func(ctx context.Context, dst io.Writer) error {
+		panic("makeslice: len out of range")
+	})
+	<-panicked.done
+	state := panicked.snapshot()
+	assert.Equal(t, jobFailed, state.Status)
+	if assert.NotNil(t, state.Error) {
+		assert.Equal(t, codeInternal, state.Error.Code)
+	}
+
+	// the jobs are loaded back, those left running are failed
+	running, err := store.create("sum", matrix.CSV)
+	assert.NoError(t, err)
+	running.update(func(state *jobState) { state.Status = jobRunning })
+
+	reloaded := newJobStore(dir)
+	got, err := reloaded.get(j.state.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, jobCancelled, got.snapshot().Status)
+	}
+	got, err = reloaded.get(running.state.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, jobFailed, got.snapshot().Status)
+	}
+
+	// the finished jobs are removed with their files once jobTTL is over,
+	// the unfinished ones are kept
+	defer func(ttl time.Duration) { jobTTL = ttl }(jobTTL)
+	jobTTL = time.Hour
+	unfinished, err := reloaded.create("sum", matrix.CSV)
+	assert.NoError(t, err)
+	release := make(chan struct{})
+	reloaded.start(unfinished, func(ctx context.Context, dst io.Writer) error {
+		<-release
+		return nil
+	})
+	assert.Zero(t, reloaded.expire(time.Now()))
+	assert.Equal(t, 3, reloaded.expire(time.Now().Add(2*time.Hour)))
+	_, err = reloaded.get(j.state.ID)
+	assert.Error(t, err)
+	_, err = reloaded.get(unfinished.state.ID)
+	assert.NoError(t, err)
+	entries, err := os.ReadDir(dir)
+	if assert.NoError(t, err) && assert.Len(t, entries, 1) {
+		assert.Equal(t, unfinished.state.ID, entries[0].Name())
+	}
+	close(release)
+	<-unfinished.done
+
+	// and at startup, with the directories left without a state
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "unsaved"), 0o700))
+	finished := unfinished.snapshot().Finished.Add(-2 * time.Hour)
+	unfinished.update(func(state *jobState) { state.Finished = &finished })
+	newJobStore(dir)
+	entries, err = os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestResultCache(t *testing.T) {
+	InitLogger()
+	dir := t.TempDir()
+	cache := newResultCache(dir, 10)
+
+	put := func(cache *resultCache, key, content string) {
+		tmp := filepath.Join(dir, key+".tmp")
+		assert.NoError(t, os.WriteFile(tmp, []byte(content), 0o600))
+		assert.NoError(t, cache.put(key, tmp, int64(len(content))))
+	}
+	has := func(key string) bool {
+		file, ok := cache.get(key)
+		if ok {
+			file.Close()
+		}
+		return ok
+	}
+
+	put(cache, "a", "1234")
+	put(cache, "b", "1234")
+	assert.True(t, has("a")) // a is now the most recently used
+	put(cache, "c", "1234")
+	assert.True(t, has("a"))
+	assert.False(t, has("b"))
+	assert.True(t, has("c"))
+	assert.NoFileExists(t, filepath.Join(dir, "b"))
+
+	// larger than the whole cache
+	put(cache, "d", "12345678901")
+	assert.False(t, has("d"))
+
+	// the results are loaded back, the least recently used first
+	now := time.Now()
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "a"), now, now.Add(-time.Hour)))
+	put(newResultCache(dir, 10), "e", "1234")
+	assert.NoFileExists(t, filepath.Join(dir, "a"))
+	assert.FileExists(t, filepath.Join(dir, "c"))
+	assert.Nil(t, newResultCache(dir, 0))
+}
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	env := func(vars map[string]string) func(string) string {
+		return func(name string) string { return vars[name] }
+	}
+
+	cfg, err := loadConfig(nil, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, defaultConfig(), cfg)
+
+	// the file is overridden by the environment, itself overridden by the flags
+	yamlFile := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, os.WriteFile(yamlFile, []byte(`
+addr: ":9090"
+process_timeout: 2m
+temp_dir: `+dir+`
+cache_size: 0
+read_buffer: 1MB
+write_buffer: 4096
+max_findings: 50
+log_encoding: json
+`), 0o600))
+	cfg, err = loadConfig([]string{"-config", yamlFile, "-max-findings", "20"}, env(map[string]string{
+		"MATRIX_ADDR":         ":7070",
+		"MATRIX_MAX_FINDINGS": "30",
+	}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, ":7070", cfg.Addr)
+		assert.Equal(t, 2*time.Minute, cfg.ProcessTimeout)
+		assert.Equal(t, dir, cfg.TempDir)
+		assert.Equal(t, byteSize(0), cfg.CacheSize)
+		assert.Equal(t, byteSize(1<<20), cfg.ReadBuffer)
+		assert.Equal(t, byteSize(4096), cfg.WriteBuffer)
+		assert.Equal(t, 20, cfg.MaxFindings)
+		assert.Equal(t, "json", cfg.LogEncoding)
+	}
+
+	// JSON, with the path given by the environment
+	jsonFile := filepath.Join(dir, "config.json")
+	assert.NoError(t, os.WriteFile(jsonFile, []byte(`{"memory_budget": "256MiB", "max_running_jobs": 8}`), 0o600))
+	cfg, err = loadConfig(nil, env(map[string]string{"MATRIX_CONFIG": jsonFile}))
+	if assert.NoError(t, err) {
+		assert.Equal(t, byteSize(256<<20), cfg.MemoryBudget)
+		assert.Equal(t, 8, cfg.MaxRunningJobs)
+	}
+
+	// every invalid setting is reported
+	_, err = loadConfig([]string{"-process-timeout", "0s", "-log-level", "loud", "-temp-dir", yamlFile}, env(nil))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "process_timeout: must be positive")
+		assert.Contains(t, err.Error(), "log_level")
+		assert.Contains(t, err.Error(), "is not a directory")
+	}
+	_, err = loadConfig(nil, env(map[string]string{"MATRIX_CACHE_SIZE": "lots"}))
+	assert.ErrorContains(t, err, "MATRIX_CACHE_SIZE")
+	assert.NoError(t, os.WriteFile(yamlFile, []byte("adr: :80\n"), 0o600))
+	_, err = loadConfig([]string{"-config", yamlFile}, env(nil))
+	assert.ErrorContains(t, err, "field adr not found")
+	_, err = loadConfig([]string{"-config", filepath.Join(dir, "missing.yaml")}, env(nil))
+	assert.Error(t, err)
+
+	for text, want := range map[string]byteSize{"1024": 1024, "64KB": 64 << 10, "1 GiB": 1 << 30, "3M": 3 << 20, "10B": 10} {
+		var size byteSize
+		assert.NoError(t, size.Set(text), text)
+		assert.Equal(t, want, size, text)
+	}
+	for _, text := range []string{"99999999999G", "9223372036854775807K", "-9999999T"} {
+		var size byteSize
+		assert.ErrorContains(t, size.Set(text), "invalid size", text)
+	}
+	_, err = loadConfig([]string{"-cache-size", "99999999999G"}, env(nil))
+	assert.Error(t, err)
+	assert.Equal(t, "64MB", byteSize(64<<20).String())
+}
+
+// build a multipart request uploading one csv file per form field
+func newFilesRequest(t *testing.T, endpoint string, files map[string]string) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for field, content := range files {
+		part, err := writer.CreateFormFile(field, field+".csv")
+		assert.NoError(t, err)
+		io.Copy(part, strings.NewReader(content))
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, endpoint, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestShutdown(t *testing.T) {
+	InitLogger()
+
+	t.Run("Job store", func(t *testing.T) {
+		store := newJobStore(t.TempDir())
+		store.slots = make(chan struct{}, 2)
+		block := func(release chan struct{}) func(ctx context.Context, dst io.Writer) error {
+			return func(ctx context.Context, dst io.Writer) error {
+				select {
+				case <-release:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		submit := func(release chan struct{}, status string) *job {
+			j, err := store.create("sum", matrix.CSV)
+			assert.NoError(t, err)
+			store.start(j, block(release))
+			assert.Eventually(t, func() bool { return j.snapshot().Status == status }, time.Second, time.Millisecond)
+			return j
+		}
+		finishing := make(chan struct{})
+		finished := submit(finishing, jobRunning)
+		cancelled := submit(nil, jobRunning)
+		queued := submit(nil, jobQueued)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		go close(finishing)
+		store.shutdown(ctx)
+
+		// the running jobs finish within the grace period or are stopped, the
+		// queued ones never start
+		assert.Equal(t, jobSucceeded, finished.snapshot().Status)
+		for _, j := range []*job{cancelled, queued} {
+			state := j.snapshot()
+			assert.Equal(t, jobFailed, state.Status)
+			if assert.NotNil(t, state.Error) {
+				assert.Equal(t, jobStopped().Detail, state.Error.Detail)
+			}
+		}
+		submit(nil, jobFailed) // once stopped
+	})
+
+	t.Run("Server", func(t *testing.T) {
+		jobs = newJobStore(t.TempDir())
+		defer func(timeout time.Duration) { shutdownTimeout = timeout }(shutdownTimeout)
+		shutdownTimeout = 200 * time.Millisecond
+
+		e := echo.New()
+		e.HideBanner, e.HidePort = true, true
+		e.Use(trackRequests)
+		started := make(chan struct{}, 2)
+		e.GET("/slow", func(c echo.Context) error {
+			started <- struct{}{}
+			time.Sleep(50 * time.Millisecond)
+			return c.String(http.StatusOK, "done")
+		})
+		stopped := make(chan struct{})
+		e.GET("/stuck", func(c echo.Context) error {
+			started <- struct{}{}
+			<-c.Request().Context().Done()
+			close(stopped)
+			return nil
+		})
+
+		ctx, stop := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() { served <- serve(ctx, e, "127.0.0.1:0") }()
+		assert.Eventually(t, func() bool { return e.ListenerAddr() != nil }, time.Second, time.Millisecond)
+		base := "http://" + e.ListenerAddr().String()
+
+		slow := make(chan string, 1)
+		go func() {
+			resp, err := http.Get(base + "/slow")
+			if err != nil {
+				slow <- err.Error()
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			slow <- string(body)
+		}()
+		go func() {
+			if resp, err := http.Get(base + "/stuck"); err == nil {
+				resp.Body.Close()
+			}
+		}()
+		<-started
+		<-started
+		stop()
+
+		// the request finishing within the grace period is answered, the
+		// other is cancelled through its context
+		assert.Equal(t, "done", <-slow)
+		assert.NoError(t, <-served)
+		select {
+		case <-stopped:
+		default:
+			t.Error("the stuck request was not cancelled")
+		}
+	})
+
+	t.Run("Temporary files", func(t *testing.T) {
+		dir := t.TempDir()
+		assert.NoError(t, os.Mkdir(filepath.Join(dir, "matrix_invert123"), 0o700))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "matrix_invert123", "invert_0_1.tmp"), nil, 0o600))
+		assert.NoError(t, os.Mkdir(filepath.Join(dir, "matrix_jobs"), 0o700))
+		for _, name := range []string{"invert_1_2.tmp", "matrix_product3", "matrix_result_4.tmp", "matrix.csv"} {
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+		}
+
+		assert.Equal(t, 4, sweepTempDir(dir))
+		entries, err := os.ReadDir(dir)
+		assert.NoError(t, err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		assert.Equal(t, []string{"matrix.csv", "matrix_jobs"}, names)
 	})
 }
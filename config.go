@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// prefix of the environment variables, MATRIX_PROCESS_TIMEOUT sets
+// -process-timeout
+const envPrefix = "MATRIX_"
+
+// Config is the configuration of the server. It is read from a YAML or JSON
+// file, then from MATRIX_* environment variables, then from the command line
+// flags, each one overriding the previous.
+type Config struct {
+	File string `yaml:"-"` // path of the configuration file
+
+	Addr            string        `yaml:"addr"`
+	ProcessTimeout  time.Duration `yaml:"process_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	TempDir         string        `yaml:"temp_dir"`
+	JobsDir         string        `yaml:"jobs_dir"`  // <temp_dir>/matrix_jobs when empty
+	CacheDir        string        `yaml:"cache_dir"` // <temp_dir>/matrix_cache when empty
+	CacheSize       byteSize      `yaml:"cache_size"`
+
+	ReadBuffer   byteSize `yaml:"read_buffer"`
+	WriteBuffer  byteSize `yaml:"write_buffer"`
+	MemoryBudget byteSize `yaml:"memory_budget"`
+	Concurrency  int      `yaml:"concurrency"` // GOMAXPROCS when 0
+
+	ValidateSize   byteSize      `yaml:"validate_size"`
+	MaxRunningJobs int           `yaml:"max_running_jobs"`
+	JobTTL         time.Duration `yaml:"job_ttl"`
+	MaxPrecision   uint          `yaml:"max_precision"`
+	MaxPlaces      int           `yaml:"max_places"`
+	MaxFindings    int           `yaml:"max_findings"`
+
+	MaxUploadSize   byteSize `yaml:"max_upload_size"` // 0 is no limit, like for the others
+	MaxRows         int      `yaml:"max_rows"`
+	MaxColumns      int      `yaml:"max_columns"`
+	MaxCellLength   int      `yaml:"max_cell_length"`
+	MaxResultDigits int      `yaml:"max_result_digits"`
+
+	LogLevel    string `yaml:"log_level"`
+	LogEncoding string `yaml:"log_encoding"`
+}
+
+// defaultConfig is the configuration of the server when nothing is set
+func defaultConfig() *Config {
+	return &Config{
+		Addr:            ":8080",
+		ProcessTimeout:  maxProcessTime,
+		ShutdownTimeout: shutdownTimeout,
+		TempDir:         tempDir,
+		CacheSize:       byteSize(cacheSize),
+		ReadBuffer:      byteSize(readBuffer),
+		WriteBuffer:     byteSize(writeBuffer),
+		MemoryBudget:    byteSize(memoryBudget),
+		Concurrency:     concurrency,
+		ValidateSize:    byteSize(validateSize),
+		MaxRunningJobs:  maxRunningJobs,
+		JobTTL:          jobTTL,
+		MaxPrecision:    maxPrecision,
+		MaxPlaces:       maxPlaces,
+		MaxFindings:     maxFindings,
+		MaxUploadSize:   byteSize(maxUploadSize),
+		MaxRows:         maxRows,
+		MaxColumns:      maxColumns,
+		MaxCellLength:   maxCellLength,
+		MaxResultDigits: maxResultDigits,
+		LogLevel:        "debug",
+		LogEncoding:     "console",
+	}
+}
+
+// flagSet binds the command line flags to the fields of c
+func (c *Config) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("BackendChallenge", flag.ContinueOnError)
+	fs.StringVar(&c.File, "config", c.File, "path of a YAML or JSON configuration file")
+	fs.StringVar(&c.Addr, "addr", c.Addr, "address the server listens on")
+	fs.DurationVar(&c.ProcessTimeout, "process-timeout", c.ProcessTimeout, "time limit of an operation")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "grace period of the requests and jobs in flight on shutdown")
+	fs.StringVar(&c.TempDir, "temp-dir", c.TempDir, "directory of the temporary files")
+	fs.StringVar(&c.JobsDir, "jobs-dir", c.JobsDir, "directory of the jobs, matrix_jobs in the temp dir by default")
+	fs.StringVar(&c.CacheDir, "cache-dir", c.CacheDir, "directory of the result cache, matrix_cache in the temp dir by default")
+	fs.Var(&c.CacheSize, "cache-size", "bytes of the cached results, 0 disables the cache")
+	fs.Var(&c.ReadBuffer, "read-buffer", "size of the buffers reading the inputs")
+	fs.Var(&c.WriteBuffer, "write-buffer", "size of the buffers writing the results")
+	fs.Var(&c.MemoryBudget, "memory-budget", "memory an operation may hold before spilling to temporary files")
+	fs.IntVar(&c.Concurrency, "concurrency", c.Concurrency, "workers of an operation, GOMAXPROCS when 0")
+	fs.Var(&c.ValidateSize, "validate-size", "inputs up to this size are validated before the response starts")
+	fs.IntVar(&c.MaxRunningJobs, "max-running-jobs", c.MaxRunningJobs, "jobs running at once")
+	fs.DurationVar(&c.JobTTL, "job-ttl", c.JobTTL, "how long a finished job is kept, 0 keeps them until deleted")
+	fs.UintVar(&c.MaxPrecision, "max-precision", c.MaxPrecision, "largest ?precision= in bits")
+	fs.IntVar(&c.MaxPlaces, "max-places", c.MaxPlaces, "largest ?places=")
+	fs.IntVar(&c.MaxFindings, "max-findings", c.MaxFindings, "largest ?max_findings=")
+	fs.Var(&c.MaxUploadSize, "max-upload-size", "largest request body, 0 is no limit")
+	fs.IntVar(&c.MaxRows, "max-rows", c.MaxRows, "most rows of an input, 0 is no limit")
+	fs.IntVar(&c.MaxColumns, "max-columns", c.MaxColumns, "most columns of an input, 0 is no limit")
+	fs.IntVar(&c.MaxCellLength, "max-cell-length", c.MaxCellLength, "most bytes of a cell, 0 is no limit")
+	fs.IntVar(&c.MaxResultDigits, "max-result-digits", c.MaxResultDigits, "most digits of a number of the result, 0 is no limit")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "debug, info, warn or error")
+	fs.StringVar(&c.LogEncoding, "log-encoding", c.LogEncoding, "console or json")
+	return fs
+}
+
+// envName is the environment variable of a flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// override sets the fields of c from the environment, then from the command
+// line args
+func (c *Config) override(args []string, getenv func(string) string) error {
+	fs := c.flagSet()
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if value := getenv(envName(f.Name)); value != "" {
+			if err := f.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid value %q: %w", envName(f.Name), value, err))
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return fs.Parse(args)
+}
+
+// load reads the configuration file at path into c, YAML or JSON
+func (c *Config) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true) // a misspelt key is an error rather than ignored
+	if err = dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// loadConfig reads the configuration from the file given by -config or
+// MATRIX_CONFIG, the environment and args, and validates it
+func loadConfig(args []string, getenv func(string) string) (*Config, error) {
+	// the environment and the flags are read a first time for the path of the
+	// file, then again over the file so that they take precedence
+	cfg := defaultConfig()
+	if err := cfg.override(args, getenv); err != nil {
+		return nil, err
+	}
+	if cfg.File != "" {
+		path := cfg.File
+		cfg = defaultConfig()
+		if err := cfg.load(path); err != nil {
+			return nil, err
+		}
+		if err := cfg.override(args, getenv); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validate reports every invalid setting at once
+func (c *Config) validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	check(c.Addr != "", "addr", "must not be empty")
+	check(c.ProcessTimeout > 0, "process_timeout", "must be positive, got %s", c.ProcessTimeout)
+	check(c.ShutdownTimeout >= 0, "shutdown_timeout", "must not be negative, got %s", c.ShutdownTimeout)
+	if info, err := os.Stat(c.TempDir); err != nil {
+		check(false, "temp_dir", "%v", err)
+	} else {
+		check(info.IsDir(), "temp_dir", "%s is not a directory", c.TempDir)
+	}
+	check(c.CacheSize >= 0, "cache_size", "must not be negative, got %s", c.CacheSize)
+	check(c.ReadBuffer >= 16, "read_buffer", "must be at least 16B, got %s", c.ReadBuffer)
+	check(c.WriteBuffer >= 16, "write_buffer", "must be at least 16B, got %s", c.WriteBuffer)
+	check(c.MemoryBudget > 0, "memory_budget", "must be positive, got %s", c.MemoryBudget)
+	check(c.Concurrency >= 0, "concurrency", "must not be negative, got %d", c.Concurrency)
+	check(c.ValidateSize >= 0, "validate_size", "must not be negative, got %s", c.ValidateSize)
+	check(c.MaxRunningJobs > 0, "max_running_jobs", "must be positive, got %d", c.MaxRunningJobs)
+	check(c.JobTTL >= 0, "job_ttl", "must not be negative, got %s", c.JobTTL)
+	check(c.MaxPrecision > 0, "max_precision", "must be positive, got %d", c.MaxPrecision)
+	check(c.MaxPlaces >= 0, "max_places", "must not be negative, got %d", c.MaxPlaces)
+	check(c.MaxFindings > 0, "max_findings", "must be positive, got %d", c.MaxFindings)
+	check(c.MaxUploadSize >= 0, "max_upload_size", "must not be negative, got %s", c.MaxUploadSize)
+	check(c.MaxRows >= 0, "max_rows", "must not be negative, got %d", c.MaxRows)
+	check(c.MaxColumns >= 0, "max_columns", "must not be negative, got %d", c.MaxColumns)
+	check(c.MaxCellLength >= 0, "max_cell_length", "must not be negative, got %d", c.MaxCellLength)
+	check(c.MaxResultDigits >= 0, "max_result_digits", "must not be negative, got %d", c.MaxResultDigits)
+	if _, err := zap.ParseAtomicLevel(c.LogLevel); err != nil {
+		check(false, "log_level", "expected debug, info, warn or error, got %q", c.LogLevel)
+	}
+	check(c.LogEncoding == "console" || c.LogEncoding == "json", "log_encoding", "expected console or json, got %q", c.LogEncoding)
+	return errors.Join(errs...)
+}
+
+// apply sets the settings of the server from c
+func (c *Config) apply() {
+	maxProcessTime = c.ProcessTimeout
+	shutdownTimeout = c.ShutdownTimeout
+	tempDir = c.TempDir
+	jobsDir = c.JobsDir
+	if jobsDir == "" {
+		jobsDir = filepath.Join(tempDir, "matrix_jobs")
+	}
+	cacheDir = c.CacheDir
+	if cacheDir == "" {
+		cacheDir = filepath.Join(tempDir, "matrix_cache")
+	}
+	cacheSize = int64(c.CacheSize)
+	readBuffer, writeBuffer = int(c.ReadBuffer), int(c.WriteBuffer)
+	memoryBudget = int64(c.MemoryBudget)
+	concurrency = c.Concurrency
+	validateSize = int64(c.ValidateSize)
+	maxRunningJobs = c.MaxRunningJobs
+	jobTTL = c.JobTTL
+	maxPrecision = c.MaxPrecision
+	maxPlaces = c.MaxPlaces
+	maxFindings = c.MaxFindings
+	maxUploadSize = int64(c.MaxUploadSize)
+	maxRows, maxColumns = c.MaxRows, c.MaxColumns
+	maxCellLength, maxResultDigits = c.MaxCellLength, c.MaxResultDigits
+}
+
+// newLogger builds the logger of the server: a development logger writing
+// console lines, or a production one writing JSON
+func newLogger(level, encoding string) (*zap.SugaredLogger, error) {
+	zcfg := zap.NewProductionConfig()
+	if encoding == "console" {
+		zcfg = zap.NewDevelopmentConfig()
+	}
+	lvl, err := zap.ParseAtomicLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	zcfg.Level = lvl
+	log, err := zcfg.Build()
+	if err != nil {
+		return nil, err
+	}
+	return log.Sugar(), nil
+}
+
+// byteSize is a number of bytes, written as 1048576, 1MB or 1MiB
+type byteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+func (b byteSize) String() string {
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if b != 0 && int64(b)%unit.size == 0 {
+			return strconv.FormatInt(int64(b)/unit.size, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(int64(b), 10) + "B"
+}
+
+// Set parses s, as a flag
+func (b *byteSize) Set(s string) error {
+	return b.UnmarshalText([]byte(s))
+}
+
+// UnmarshalText parses a size such as 64MB, as a value of the config file
+func (b *byteSize) UnmarshalText(text []byte) error {
+	s := strings.TrimSpace(string(text))
+	size := int64(1)
+	for _, unit := range byteUnits {
+		if number, ok := strings.CutSuffix(s, unit.suffix); ok {
+			s, size = strings.TrimSpace(number), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q, expected a number of bytes such as 1048576 or 1MB", string(text))
+	}
+	if n > math.MaxInt64/size || n < math.MinInt64/size {
+		return fmt.Errorf("invalid size %q, larger than %s", string(text), byteSize(math.MaxInt64))
+	}
+	*b = byteSize(n * size)
+	return nil
+}
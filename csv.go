@@ -1,11 +1,11 @@
 package main
 
 import (
-	"encoding/csv"
 	"math/rand"
 	"os"
-	"strconv"
 	"time"
+
+	"github.com/league/BackendChallenge/matrix"
 )
 
 func BigCVS() {
@@ -16,32 +16,12 @@ func BigCVS() {
 	}
 	defer file.Close()
 
-	// init csv.Writer instance
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
 	// set random seed
-	rand.Seed(time.Now().UnixNano())
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 
 	var matrixNum = 2000
 	// generate the matrix
-	for i := 0; i < matrixNum; i++ {
-		row := make([]string, matrixNum)
-		for j := 0; j < matrixNum; j++ {
-			// generate random number between -100 and 100
-			// if the num is 0, redo
-			for {
-				num := rand.Intn(201) - 100
-				if num != 0 {
-					row[j] = strconv.Itoa(num)
-					break
-				}
-			}
-		}
-		// output to file
-		err = writer.Write(row)
-		if err != nil {
-			panic(err)
-		}
+	if err = matrix.WriteRandom(file, matrixNum, matrixNum, rng); err != nil {
+		panic(err)
 	}
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// media type of a Server-Sent Events stream
+const mimeEventStream = "text/event-stream"
+
+// eventInterval is how often the progress of a job is sampled for its events
+var eventInterval = 500 * time.Millisecond
+
+// Events streams the progress of a job as Server-Sent Events: a "progress"
+// event whenever the rows or bytes read or the phase change, then a "done"
+// event with the final state once the job is finished. A client may subscribe
+// while the job runs and fetch the result once done is received.
+func (s *jobStore) Events(c echo.Context) error {
+	j, err := s.get(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, mimeEventStream)
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("X-Accel-Buffering", "no") // keep proxies from buffering the stream
+	resp.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(eventInterval)
+	defer ticker.Stop()
+
+	var last jobState
+	for {
+		select {
+		case <-j.done:
+			return writeEvent(resp, "done", j.snapshot())
+		case <-c.Request().Context().Done():
+			return nil // client has gone
+		case <-ticker.C:
+		}
+
+		state := j.snapshot()
+		if state.Status == last.Status && state.Rows == last.Rows && state.Bytes == last.Bytes && state.Phase == last.Phase {
+			continue
+		}
+		last = state
+		if err = writeEvent(resp, "progress", state); err != nil {
+			return err
+		}
+	}
+}
+
+// writeEvent writes one event of the stream and flushes it to the client
+func writeEvent(resp *echo.Response, event string, state jobState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(resp, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	resp.Flush()
+	return nil
+}
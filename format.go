@@ -0,0 +1,179 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/league/BackendChallenge/matrix"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// media types accepted for each output format
+var mediaFormats = map[string]matrix.Format{
+	"text/csv":                    matrix.CSV,
+	"text/tab-separated-values":   matrix.TSV,
+	"application/json":            matrix.JSON,
+	"application/x-ndjson":        matrix.NDJSON,
+	"application/ndjson":          matrix.NDJSON,
+	"text/plain":                  matrix.Text,
+	"application/x-matrix-market": matrix.MatrixMarket,
+	"text/*":                      matrix.CSV,
+	"application/*":               matrix.JSON,
+	"*/*":                         matrix.CSV,
+}
+
+// media types of the uploaded files for each input format
+var inputMediaFormats = map[string]matrix.Format{
+	"text/csv":                    matrix.CSV,
+	"text/tab-separated-values":   matrix.TSV,
+	"application/json":            matrix.JSON,
+	"application/x-ndjson":        matrix.NDJSON,
+	"application/ndjson":          matrix.NDJSON,
+	"text/plain":                  matrix.Text,
+	"application/x-matrix-market": matrix.MatrixMarket,
+}
+
+// file extensions for each input format
+var extensionFormats = map[string]matrix.Format{
+	".csv":    matrix.CSV,
+	".tsv":    matrix.TSV,
+	".tab":    matrix.TSV,
+	".json":   matrix.JSON,
+	".ndjson": matrix.NDJSON,
+	".jsonl":  matrix.NDJSON,
+	".txt":    matrix.Text,
+	".dat":    matrix.Text,
+	".mtx":    matrix.MatrixMarket,
+}
+
+// detectInputFormat picks the format of an uploaded file from the ?input=
+// query param, or else from the file extension, or else from its media type
+func detectInputFormat(c echo.Context, filename, contentType string) (matrix.Format, error) {
+	if name := c.QueryParam("input"); name != "" {
+		format, err := matrix.ParseFormat(name)
+		if err != nil {
+			return "", newProblem(http.StatusBadRequest, codeUnsupportedType, "%v", err)
+		}
+		return format, nil
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if format, ok := extensionFormats[ext]; ok {
+		return format, nil
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if format, ok := inputMediaFormats[mediaType]; ok {
+			return format, nil
+		}
+	}
+
+	logger.Errorf("File type %s is not supported", ext)
+	return "", newProblem(http.StatusBadRequest, codeUnsupportedType, "unsupported file type %q: expected a .csv, .tsv, .json, .ndjson, .txt or .mtx file, or ?input= set to one of %s", ext, formatNames())
+}
+
+// detectBodyFormat picks the format of a raw request body from the ?input=
+// query param, or else from its media type. CSV is used when neither is given.
+func detectBodyFormat(c echo.Context, contentType string) (matrix.Format, error) {
+	if name := c.QueryParam("input"); name != "" {
+		format, err := matrix.ParseFormat(name)
+		if err != nil {
+			return "", newProblem(http.StatusBadRequest, codeUnsupportedType, "%v", err)
+		}
+		return format, nil
+	}
+	if contentType == "" {
+		return matrix.CSV, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		if format, ok := inputMediaFormats[mediaType]; ok {
+			return format, nil
+		}
+	}
+	logger.Errorf("Media type %s is not supported", contentType)
+	return "", newProblem(http.StatusUnsupportedMediaType, codeUnsupportedType, "unsupported media type %q: expected text/csv, text/tab-separated-values, application/json, application/x-ndjson, text/plain, application/x-matrix-market or multipart/form-data", contentType)
+}
+
+// names of the supported formats, for error messages
+func formatNames() string {
+	names := make([]string, len(matrix.Formats))
+	for i, f := range matrix.Formats {
+		names[i] = string(f)
+	}
+	return strings.Join(names, ", ")
+}
+
+// negotiateFormat picks the output format from the ?format= query param, or
+// else from the Accept header. CSV is used when neither is given.
+func negotiateFormat(c echo.Context) (matrix.Format, error) {
+	if name := c.QueryParam("format"); name != "" {
+		format, err := matrix.ParseFormat(name)
+		if err != nil {
+			return "", newProblem(http.StatusBadRequest, codeUnsupportedType, "%v", err)
+		}
+		return format, nil
+	}
+
+	// from here the result depends on the Accept header
+	c.Response().Header().Set(echo.HeaderVary, echo.HeaderAccept)
+	accept := c.Request().Header.Get(echo.HeaderAccept)
+	if accept == "" {
+		return matrix.CSV, nil
+	}
+
+	// media ranges sorted by decreasing quality, ties keep the client's order
+	type mediaRange struct {
+		format matrix.Format
+		q      float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		format, ok := mediaFormats[mediaType]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, mediaRange{format: format, q: q})
+		}
+	}
+	if len(ranges) == 0 {
+		return "", newProblem(http.StatusNotAcceptable, codeNotAcceptable, "no acceptable format, supported media types are text/csv, text/tab-separated-values, application/json, application/x-ndjson, text/plain and application/x-matrix-market")
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return ranges[0].format, nil
+}
+
+// checkOutputFormat rejects, before the upload is read, the MatrixMarket
+// output of an operation whose cells are not all numbers: the key/value rows
+// of stats, and the fractions of an inverse in the int mode or of any result
+// in the rational mode, unless they are written with ?places= decimals
+func checkOutputFormat(c echo.Context, operation string, format matrix.Format) error {
+	if format != matrix.MatrixMarket {
+		return nil
+	}
+	if operation == "stats" {
+		return newProblem(http.StatusNotAcceptable, codeNotAcceptable, "stats cannot be written in %s format", format)
+	}
+	if c.QueryParam("places") != "" {
+		return nil
+	}
+	numeric := matrix.Numeric(c.QueryParam("numeric"))
+	if numeric == matrix.Rational || (operation == "inverse" && (numeric == "" || numeric == matrix.Int)) {
+		return newProblem(http.StatusNotAcceptable, codeNotAcceptable, "the fractions of %s cannot be written in %s format, use ?numeric=decimal or ?places=", operation, format)
+	}
+	return nil
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/league/BackendChallenge/matrix"
+)
+
+// statuses of a job
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// no of jobs running at once, the others wait in the queue
+var maxRunningJobs = 4
+
+// how long a finished job is kept before it is removed with its files, 0
+// keeps them until they are deleted
+var jobTTL = 24 * time.Hour
+
+// jobsDir is where the job store keeps the inputs, the result and the state
+// of every job, one directory per job
+var jobsDir = filepath.Join(tempDir, "matrix_jobs")
+
+// jobOperation runs an operation of the API on the inputs of a job
+type jobOperation struct {
+	fields []string // form fields of the inputs, "file" or "a" and "b"
+	run    func(ctx context.Context, in []matrix.Input, dst io.Writer, format matrix.Format, opts []matrix.Option) error
+}
+
+func unaryJob(op matrix.Operation) jobOperation {
+	return jobOperation{
+		fields: []string{"file"},
+		run: func(ctx context.Context, in []matrix.Input, dst io.Writer, _ matrix.Format, opts []matrix.Option) error {
+			return op(ctx, in[0], dst, opts...)
+		},
+	}
+}
+
+func binaryJob(op matrix.BinaryOperation) jobOperation {
+	return jobOperation{
+		fields: []string{"a", "b"},
+		run: func(ctx context.Context, in []matrix.Input, dst io.Writer, _ matrix.Format, opts []matrix.Option) error {
+			return op(ctx, in[0], in[1], dst, opts...)
+		},
+	}
+}
+
+// operations that can be run as jobs, by name
+var jobOperations = map[string]jobOperation{
+	"echo":        unaryJob(matrix.Echo),
+	"transpose":   unaryJob(matrix.Transpose),
+	"inverse":     unaryJob(matrix.Inverse),
+	"flatten":     unaryJob(matrix.Flatten),
+	"sum":         unaryJob(matrix.Sum),
+	"multiply":    unaryJob(matrix.Multiply),
+	"determinant": unaryJob(matrix.Determinant),
+	"matmul":      binaryJob(matrix.MatMul),
+	"add":         binaryJob(matrix.Add),
+	"subtract":    binaryJob(matrix.Subtract),
+	"hadamard":    binaryJob(matrix.Hadamard),
+	"stats": {
+		fields: []string{"file"},
+		run: func(ctx context.Context, in []matrix.Input, dst io.Writer, format matrix.Format, opts []matrix.Option) error {
+			stats, err := matrix.ComputeStats(ctx, in[0], opts...)
+			if err != nil {
+				return err
+			}
+			if format == matrix.JSON || format == matrix.NDJSON {
+				return json.NewEncoder(dst).Encode(stats)
+			}
+			w := matrix.NewRowWriter(dst, format)
+			for _, record := range stats.Records() {
+				if err = w.WriteRow(record); err != nil {
+					return err
+				}
+			}
+			return w.Close()
+		},
+	},
+	"validate": {
+		fields: []string{"file"},
+		run: func(ctx context.Context, in []matrix.Input, dst io.Writer, _ matrix.Format, opts []matrix.Option) error {
+			report, err := matrix.Validate(ctx, in[0], opts...)
+			if err != nil {
+				return err
+			}
+			return json.NewEncoder(dst).Encode(report)
+		},
+	},
+}
+
+// names of the operations that can be run as jobs, for error messages
+func jobOperationNames() string {
+	names := make([]string, 0, len(jobOperations))
+	for name := range jobOperations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// jobState is what GET /jobs/{id} answers, and what is saved in job.json
+type jobState struct {
+	ID        string        `json:"id"`
+	Operation string        `json:"operation"`
+	Status    string        `json:"status"`
+	Rows      int64         `json:"rows"`            // rows of the inputs processed so far
+	Bytes     int64         `json:"bytes"`           // bytes of the inputs read so far
+	Size      int64         `json:"size"`            // bytes of the inputs
+	Phase     matrix.Phase  `json:"phase,omitempty"` // stage of an operation running in several passes
+	Format    matrix.Format `json:"format"`
+	Error     *problem      `json:"error,omitempty"`
+	Created   time.Time     `json:"created"`
+	Started   *time.Time    `json:"started,omitempty"`
+	Finished  *time.Time    `json:"finished,omitempty"`
+}
+
+// job is an operation running in the background on inputs saved on disk
+type job struct {
+	mu    sync.Mutex
+	state jobState
+	rows  atomic.Int64
+	bytes atomic.Int64
+	dir   string
+
+	run    func(ctx context.Context, dst io.Writer) error
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{} // closed once the job is finished
+}
+
+// snapshot returns the current state of the job
+func (j *job) snapshot() jobState {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	state := j.state
+	state.Rows = j.rows.Load()
+	state.Bytes = j.bytes.Load()
+	return state
+}
+
+// update changes the state of the job and saves it
+func (j *job) update(fn func(state *jobState)) {
+	j.mu.Lock()
+	fn(&j.state)
+	j.mu.Unlock()
+	if err := j.save(); err != nil {
+		logger.Errorf("fail to save job %s: %v", j.state.ID, err)
+	}
+}
+
+// save writes the state of the job to job.json, through a temporary file so
+// that a crash never leaves it half written
+func (j *job) save() error {
+	data, err := json.Marshal(j.snapshot())
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(j.dir, "job.json.tmp")
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(j.dir, "job.json"))
+}
+
+// errServerStopped is the cause of the jobs stopped by a shutdown
+var errServerStopped = errors.New("the server stopped before the job finished")
+
+// jobStopped is the error of a job stopped by a shutdown
+func jobStopped() *problem {
+	return newProblem(http.StatusInternalServerError, codeInternal, "%v", errServerStopped)
+}
+
+// jobStore keeps the jobs in a directory, the states saved by a previous run
+// of the server are loaded back so their results can still be fetched
+type jobStore struct {
+	dir   string
+	slots chan struct{}
+
+	// the contexts of the jobs derive from ctx, cancelled once the grace
+	// period of a shutdown is over, and no queued job starts once draining
+	// is closed
+	ctx      context.Context
+	stop     context.CancelCauseFunc
+	draining chan struct{}
+	running  sync.WaitGroup
+
+	mu       sync.Mutex
+	jobs     map[string]*job
+	stopping bool
+}
+
+func newJobStore(dir string) *jobStore {
+	s := &jobStore{dir: dir, slots: make(chan struct{}, maxRunningJobs), draining: make(chan struct{}), jobs: make(map[string]*job)}
+	s.ctx, s.stop = context.WithCancelCause(context.Background())
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Errorf("fail to load the jobs: %v", err)
+		}
+		return s
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		j, err := loadJob(path)
+		if err != nil {
+			// the server stopped before the state of the job was saved
+			logger.Warnf("removing %s, not a job: %v", path, err)
+			os.RemoveAll(path)
+			continue
+		}
+		s.jobs[j.state.ID] = j
+	}
+	s.expire(time.Now())
+	if jobTTL > 0 {
+		go s.sweep(min(jobTTL, time.Minute))
+	}
+	return s
+}
+
+// sweep expires the finished jobs every interval until the store is shut down
+func (s *jobStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.expire(now)
+		case <-s.draining:
+			return
+		}
+	}
+}
+
+// expire removes the jobs finished more than jobTTL before now with their
+// files, it returns the no of jobs removed
+func (s *jobStore) expire(now time.Time) int {
+	if jobTTL <= 0 {
+		return 0
+	}
+	var expired []*job
+	s.mu.Lock()
+	for id, j := range s.jobs {
+		if finished := j.snapshot().Finished; finished != nil && now.Sub(*finished) > jobTTL {
+			delete(s.jobs, id)
+			expired = append(expired, j)
+		}
+	}
+	s.mu.Unlock()
+	for _, j := range expired {
+		if err := os.RemoveAll(j.dir); err != nil {
+			logger.Errorf("fail to remove job %s: %v", j.state.ID, err)
+		}
+	}
+	if len(expired) > 0 {
+		logger.Infof("removed %d jobs finished more than %s ago", len(expired), jobTTL)
+	}
+	return len(expired)
+}
+
+// loadJob reads back a job saved by a previous run, the jobs it left
+// unfinished are failed
+func loadJob(dir string) (*job, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "job.json"))
+	if err != nil {
+		return nil, err
+	}
+	j := &job{dir: dir, done: make(chan struct{})}
+	if err = json.Unmarshal(data, &j.state); err != nil {
+		return nil, err
+	}
+	j.rows.Store(j.state.Rows)
+	j.bytes.Store(j.state.Bytes)
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+	close(j.done)
+
+	if j.state.Status == jobQueued || j.state.Status == jobRunning {
+		j.update(func(state *jobState) {
+			now := time.Now()
+			state.Status = jobFailed
+			state.Error = jobStopped()
+			state.Finished = &now
+		})
+	}
+	return j, nil
+}
+
+// create prepares the directory of a new job, its inputs are saved there
+// before it is started
+func (s *jobStore) create(operation string, format matrix.Format) (*job, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	j := &job{
+		state: jobState{
+			ID:        hex.EncodeToString(id),
+			Operation: operation,
+			Status:    jobQueued,
+			Format:    format,
+			Created:   time.Now(),
+		},
+		done: make(chan struct{}),
+	}
+	j.dir = filepath.Join(s.dir, j.state.ID)
+	if err := os.MkdirAll(j.dir, 0o700); err != nil {
+		return nil, err
+	}
+	// DELETE cancels the job whether it is queued or running, it runs for at
+	// most maxProcessTime once started
+	j.ctx, j.cancel = context.WithCancel(s.ctx)
+	return j, nil
+}
+
+// start queues the job, run writes its result
+func (s *jobStore) start(j *job, run func(ctx context.Context, dst io.Writer) error) {
+	j.run = run
+	j.update(func(*jobState) {})
+	s.mu.Lock()
+	s.jobs[j.state.ID] = j
+	stopping := s.stopping
+	if !stopping {
+		s.running.Add(1)
+	}
+	s.mu.Unlock()
+	if stopping {
+		j.finish(errServerStopped)
+		close(j.done)
+		return
+	}
+
+	go func() {
+		defer s.running.Done()
+		defer close(j.done)
+		defer func() {
+			// a bug in an operation fails the job rather than the server
+			if r := recover(); r != nil {
+				logger.Errorf("job %s panicked: %v\n%s", j.state.ID, r, debug.Stack())
+				j.finish(fmt.Errorf("panic: %v", r))
+			}
+		}()
+		select {
+		case s.slots <- struct{}{}:
+			defer func() { <-s.slots }()
+		case <-j.ctx.Done():
+			j.finish(j.ctx.Err())
+			return
+		case <-s.draining:
+			j.finish(errServerStopped)
+			return
+		}
+		select {
+		case <-s.draining:
+			// got the slot of a job that finished while draining
+			j.finish(errServerStopped)
+			return
+		default:
+		}
+
+		j.update(func(state *jobState) {
+			now := time.Now()
+			state.Status = jobRunning
+			state.Started = &now
+		})
+		ctx, cancel := operationContext(j.ctx)
+		defer cancel()
+		j.finish(j.runToFile(ctx))
+	}()
+}
+
+// runToFile runs the job, writing its result into the file result
+func (j *job) runToFile(ctx context.Context) error {
+	result, err := os.Create(filepath.Join(j.dir, "result"))
+	if err != nil {
+		return err
+	}
+	if err = j.run(ctx, result); err != nil {
+		result.Close()
+		return err
+	}
+	return result.Close()
+}
+
+// finish records how the job ended
+func (j *job) finish(err error) {
+	j.update(func(state *jobState) {
+		now := time.Now()
+		state.Finished = &now
+		switch {
+		case err == nil:
+			state.Status = jobSucceeded
+		case errors.Is(err, errServerStopped) || context.Cause(j.ctx) == errServerStopped:
+			state.Status = jobFailed
+			state.Error = jobStopped()
+		case errors.Is(err, context.Canceled) && j.ctx.Err() != nil:
+			state.Status = jobCancelled
+		default:
+			state.Status = jobFailed
+			state.Error = problemOf(operationError(err))
+		}
+	})
+}
+
+// shutdown stops starting the queued jobs and waits for the running ones
+// until ctx is done, the jobs still running then are stopped. Every job is
+// failed with errServerStopped rather than left queued or running.
+func (s *jobStore) shutdown(ctx context.Context) {
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		return
+	}
+	s.stopping = true
+	s.mu.Unlock()
+	close(s.draining)
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	logger.Warnf("stopping the jobs still running")
+	s.stop(errServerStopped)
+	<-done
+}
+
+func (s *jobStore) get(id string) (*job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return nil, newProblem(http.StatusNotFound, codeNotFound, "job %s not found", id)
+	}
+	return j, nil
+}
+
+// Submit saves the uploaded matrices and starts the operation named by
+// ?operation= or the "operation" form field, it answers 202 with the state of
+// the job
+func (s *jobStore) Submit(c echo.Context) error {
+	name := c.QueryParam("operation")
+	if name == "" && isMultipart(c) {
+		name = c.FormValue("operation")
+	}
+	op, ok := jobOperations[name]
+	if !ok {
+		return newProblem(http.StatusBadRequest, codeInvalidParameter, "invalid operation %q, expected one of %s", name, jobOperationNames())
+	}
+	format, err := negotiateFormat(c)
+	if err != nil {
+		return err
+	}
+	if err = checkOutputFormat(c, name, format); err != nil {
+		return err
+	}
+	opts, err := operationOptions(c, format)
+	if err != nil {
+		return err
+	}
+
+	uploads, err := openJobUploads(c, op.fields)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, u := range uploads {
+			u.Close()
+		}
+	}()
+
+	j, err := s.create(name, format)
+	if err != nil {
+		return newProblem(http.StatusInternalServerError, codeInternal, "fail to create job: %v", err)
+	}
+	// the request's files are removed once it is answered, keep a copy
+	formats := make([]matrix.Format, len(uploads))
+	for i, u := range uploads {
+		formats[i] = u.Format
+		size, err := saveFile(filepath.Join(j.dir, op.fields[i]), u)
+		if err != nil {
+			os.RemoveAll(j.dir)
+			var p *problem
+			if errors.As(err, &p) {
+				return p // larger than the upload limit
+			}
+			return newProblem(http.StatusInternalServerError, codeInternal, "fail to save the upload: %v", err)
+		}
+		j.state.Size += size
+	}
+
+	// counted under the operation rather than the route
+	opts = append(opts, metrics.options(name, func(rows int) { j.rows.Add(int64(rows)) })...)
+	opts = append(opts,
+		matrix.WithPhase(func(phase matrix.Phase) {
+			j.mu.Lock()
+			j.state.Phase = phase
+			j.mu.Unlock()
+		}),
+	)
+	s.start(j, func(ctx context.Context, dst io.Writer) error {
+		in := make([]matrix.Input, len(op.fields))
+		for i, field := range op.fields {
+			file, err := os.Open(filepath.Join(j.dir, field))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			in[i] = matrix.Input{Reader: &countingReader{Reader: file, n: &j.bytes}, Format: formats[i]}
+		}
+		return op.run(ctx, in, dst, format, opts)
+	})
+
+	c.Response().Header().Set(echo.HeaderLocation, "/jobs/"+j.state.ID)
+	return c.JSON(http.StatusAccepted, j.snapshot())
+}
+
+// openJobUploads opens the matrices uploaded in the given form fields, a
+// single one may also be sent as the raw body
+func openJobUploads(c echo.Context, fields []string) ([]*upload, error) {
+	if len(fields) == 1 {
+		src, err := openUpload(c)
+		if err != nil {
+			return nil, err
+		}
+		return []*upload{src}, nil
+	}
+	if !isMultipart(c) {
+		return nil, newProblem(http.StatusBadRequest, codeBadRequest, "%s must be uploaded as multipart form files", strings.Join(fields, " and "))
+	}
+	form, err := parseForm(c)
+	if err != nil {
+		return nil, err
+	}
+	uploads := make([]*upload, 0, len(fields))
+	for _, field := range fields {
+		u, err := openFormFile(c, form, field)
+		if err != nil {
+			for _, u := range uploads {
+				u.Close()
+			}
+			form.RemoveAll()
+			return nil, err
+		}
+		uploads = append(uploads, u)
+	}
+	uploads[0].form = form // removed with the first upload
+	return uploads, nil
+}
+
+// saveFile copies src into the file at path, returning the no of bytes
+// copied
+func saveFile(path string, src io.Reader) (int64, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(file, src)
+	if err != nil {
+		file.Close()
+		return n, err
+	}
+	return n, file.Close()
+}
+
+// countingReader adds the bytes read to n
+type countingReader struct {
+	io.Reader
+	n *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// Status answers the state of a job and the rows processed so far
+func (s *jobStore) Status(c echo.Context) error {
+	j, err := s.get(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, j.snapshot())
+}
+
+// Result streams the result of a job once it succeeded, or answers the error
+// that made it fail
+func (s *jobStore) Result(c echo.Context) error {
+	j, err := s.get(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	state := j.snapshot()
+	switch state.Status {
+	case jobSucceeded:
+	case jobFailed:
+		return state.Error
+	case jobCancelled:
+		return newProblem(http.StatusConflict, codeJobCancelled, "job %s was cancelled", state.ID)
+	default:
+		return newProblem(http.StatusConflict, codeJobNotFinished, "job %s is %s", state.ID, state.Status)
+	}
+
+	result, err := os.Open(filepath.Join(j.dir, "result"))
+	if err != nil {
+		return newProblem(http.StatusInternalServerError, codeInternal, "fail to open the result: %v", err)
+	}
+	defer result.Close()
+	contentType := state.Format.ContentType()
+	if state.Operation == "validate" {
+		contentType = matrix.JSON.ContentType()
+	}
+	return c.Stream(http.StatusOK, contentType, result)
+}
+
+// Cancel stops a job if it is not finished, then removes it with its files
+func (s *jobStore) Cancel(c echo.Context) error {
+	j, err := s.get(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	j.cancel()
+	<-j.done
+
+	s.mu.Lock()
+	delete(s.jobs, j.state.ID)
+	s.mu.Unlock()
+	if err = os.RemoveAll(j.dir); err != nil {
+		logger.Errorf("fail to remove job %s: %v", j.state.ID, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// limitUploads bounds the request bodies to maxUploadSize: a body announcing
+// a larger Content-Length is answered 413 up front, and any other fails once
+// that many bytes are read, wherever it is read
+func limitUploads(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if maxUploadSize <= 0 {
+			return next(c)
+		}
+		req := c.Request()
+		if req.ContentLength > maxUploadSize {
+			return uploadTooLarge()
+		}
+		req.Body = &limitedBody{ReadCloser: req.Body, left: maxUploadSize}
+		return next(c)
+	}
+}
+
+func uploadTooLarge() *problem {
+	return newProblem(http.StatusRequestEntityTooLarge, codeUploadTooLarge, "the upload is larger than %s", byteSize(maxUploadSize))
+}
+
+// limitedBody fails with a 413 problem once more than left bytes are read.
+// The problem is kept in the chain of the errors of the matrix package and of
+// the multipart reader, so it is answered as such.
+type limitedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if int64(len(p)) > b.left+1 {
+		p = p[:b.left+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.left {
+		b.left = 0
+		return n - 1, uploadTooLarge()
+	}
+	b.left -= int64(n)
+	return n, err
+}
+
+// parseForm parses the multipart form of the request, files larger than 32MB
+// are spooled to disk
+func parseForm(c echo.Context) (*multipart.Form, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		var p *problem
+		if errors.As(err, &p) {
+			return nil, p
+		}
+		return nil, newProblem(http.StatusBadRequest, codeBadRequest, "form parse error: %v", err)
+	}
+	return form, nil
+}
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 )
 
 // Run with
-//		go run .
+//		go run . [-config config.yaml] [flags]
+// see go run . -h for the settings, also read from MATRIX_* environment variables
 // Send request with:
 //		curl -F 'file=@/path/matrix.csv' "localhost:8080/echo"
 
@@ -18,11 +27,30 @@ func InitLogger() {
 }
 
 func main() {
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	if logger, err = newLogger(cfg.LogLevel, cfg.LogEncoding); err != nil {
+		fmt.Fprintf(os.Stderr, "fail to create the logger: %v\n", err)
+		os.Exit(2)
+	}
+	cfg.apply()
+	sweepTempDir(tempDir)
+
 	e := echo.New()
-	InitLogger()
 	Init(e)
 
-	if err := e.Start(":8080"); err != nil {
+	// SIGTERM is how Kubernetes stops a pod
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = serve(ctx, e, cfg.Addr)
+	sweepTempDir(tempDir)
+	if err != nil {
 		logger.Fatal(err.Error())
 	}
 }
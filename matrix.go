@@ -1,106 +1,492 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"errors"
-	"fmt"
 	"github.com/labstack/echo/v4"
+	"github.com/league/BackendChallenge/matrix"
+	"io"
+	"math/big"
+	"mime"
 	"mime/multipart"
-	"path/filepath"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
-const (
-	readBufferSize  = 64 * 1024  // 64KB
-	writeBufferSize = 128 * 1024 // 128KB
+// settings of the server, their defaults are overridden by the Config
+var (
 	maxProcessTime  = 15 * time.Minute
+	shutdownTimeout = 30 * time.Second // grace period of the requests and jobs in flight
+	tempDir         = "./"
+	maxPrecision    = uint(4096) // bits of the decimal numbers
+	maxPlaces       = 100        // decimals of the formatted numbers
+	maxFindings     = 10000
 
-	Option_echo    = "Echo"
-	Option_invert  = "Invert"
-	Option_flatten = "Flatten"
+	maxUploadSize   = int64(16 << 30) // 16GB
+	maxRows         = 0               // no limit
+	maxColumns      = 1 << 20
+	maxCellLength   = 10000 // bytes
+	maxResultDigits = 50000000
 
-	Method_Addition = "Addition"
-	Method_Multiply = "Multiplication"
+	readBuffer   = 64 * 1024       // 64KB
+	writeBuffer  = 128 * 1024      // 128KB
+	memoryBudget = int64(64 << 20) // 64MB
+	concurrency  = 0               // GOMAXPROCS
 )
 
 func Init(e *echo.Echo) {
+	e.HTTPErrorHandler = problemHandler
+	e.Use(trackRequests, recordMetrics, limitUploads)
+	results = newResultCache(cacheDir, cacheSize)
+	jobs = newJobStore(jobsDir)
 	setController(e)
+	setJobController(e, jobs)
+	e.GET("/metrics", Metrics)
 }
 
 func setController(e *echo.Echo) {
 	e.POST("/echo", func(c echo.Context) error { return Echo(c) })
-	e.POST("/invert", func(c echo.Context) error { return Invert(c) })
+	e.POST("/invert", func(c echo.Context) error { return Transpose(c) }) // historical name of /transpose
+	e.POST("/transpose", func(c echo.Context) error { return Transpose(c) })
+	e.POST("/inverse", func(c echo.Context) error { return Inverse(c) })
 	e.POST("/flatten", func(c echo.Context) error { return Flatten(c) })
 	e.POST("/sum", func(c echo.Context) error { return Sum(c) })
 	e.POST("/multiply", func(c echo.Context) error { return Multiply(c) })
+	e.POST("/stats", func(c echo.Context) error { return Stats(c) })
+	e.POST("/validate", func(c echo.Context) error { return Validate(c) })
+	e.POST("/determinant", func(c echo.Context) error { return Determinant(c) })
+	e.POST("/matmul", func(c echo.Context) error { return MatMul(c) })
+	e.POST("/add", func(c echo.Context) error { return Add(c) })
+	e.POST("/subtract", func(c echo.Context) error { return Subtract(c) })
+	e.POST("/hadamard", func(c echo.Context) error { return Hadamard(c) })
+}
+
+func setJobController(e *echo.Echo, jobs *jobStore) {
+	e.POST("/jobs", jobs.Submit)
+	e.GET("/jobs/:id", jobs.Status)
+	e.GET("/jobs/:id/result", jobs.Result)
+	e.GET("/jobs/:id/events", jobs.Events)
+	e.DELETE("/jobs/:id", jobs.Cancel)
 }
 
 func Echo(c echo.Context) error {
-	return printMatrix(c, Option_echo)
+	return runOperation(c, matrix.Echo)
+}
+
+func Transpose(c echo.Context) error {
+	return runOperation(c, matrix.Transpose)
 }
 
-func Invert(c echo.Context) error {
-	return printMatrix(c, Option_invert)
+func Inverse(c echo.Context) error {
+	return runOperation(c, matrix.Inverse)
 }
 
 func Flatten(c echo.Context) error {
-	return printMatrix(c, Option_flatten)
+	return runOperation(c, matrix.Flatten)
 }
 
 func Sum(c echo.Context) error {
-	return calcMatrix(c, Method_Addition)
+	return runOperation(c, matrix.Sum)
 }
 
 func Multiply(c echo.Context) error {
-	return calcMatrix(c, Method_Multiply)
+	return runOperation(c, matrix.Multiply)
+}
+
+// Stats answers the structural properties of the uploaded matrix, as key/value rows or as a JSON object
+func Stats(c echo.Context) error {
+	format, err := negotiateFormat(c)
+	if err != nil {
+		return err
+	}
+	if err = checkOutputFormat(c, strings.TrimPrefix(c.Path(), "/"), format); err != nil {
+		return err
+	}
+	opts, err := operationOptions(c, format)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := operationContext(c.Request().Context())
+	defer cancel()
+
+	src, err := openUpload(c)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	stats, err := matrix.ComputeStats(ctx, src.Input, opts...)
+	if err != nil {
+		return operationError(err)
+	}
+	if format == matrix.JSON || format == matrix.NDJSON {
+		return c.JSON(http.StatusOK, stats)
+	}
+
+	w := matrix.NewRowWriter(streamResponse(c, format), format)
+	for _, record := range stats.Records() {
+		if err = w.WriteRow(record); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// Validate answers a JSON report of every problem found in the uploaded matrix,
+// the status is 200 whether the matrix is valid or not
+func Validate(c echo.Context) error {
+	opts, err := operationOptions(c, matrix.JSON)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := operationContext(c.Request().Context())
+	defer cancel()
+
+	src, err := openUpload(c)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	report, err := matrix.Validate(ctx, src.Input, opts...)
+	if err != nil {
+		return operationError(err)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+func Determinant(c echo.Context) error {
+	return runOperation(c, matrix.Determinant)
+}
+
+func MatMul(c echo.Context) error {
+	return runBinaryOperation(c, matrix.MatMul)
+}
+
+func Add(c echo.Context) error {
+	return runBinaryOperation(c, matrix.Add)
+}
+
+func Subtract(c echo.Context) error {
+	return runBinaryOperation(c, matrix.Subtract)
+}
+
+func Hadamard(c echo.Context) error {
+	return runBinaryOperation(c, matrix.Hadamard)
+}
+
+// run a matrix operation on the uploaded file and stream its result to the client
+func runOperation(c echo.Context, op matrix.Operation) error {
+	format, err := negotiateFormat(c)
+	if err != nil {
+		return err
+	}
+	if err = checkOutputFormat(c, strings.TrimPrefix(c.Path(), "/"), format); err != nil {
+		return err
+	}
+	opts, err := operationOptions(c, format)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := operationContext(c.Request().Context())
+	defer cancel()
+
+	src, err := openUpload(c)
+	if err != nil {
+		logger.Errorf("prepare reader error: %v", err)
+		return err
+	}
+	defer src.Close()
+
+	run := func(w io.Writer) error {
+		return op(ctx, src.Input, w, opts...)
+	}
+	if !src.rewindable() {
+		// a raw body is streamed to the operation, it cannot be hashed
+		// before and is not cached
+		return writeResult(c, format, src.size, run)
+	}
+	key, err := resultKey(c, format, src)
+	if err != nil {
+		return err
+	}
+	if served, err := results.serve(c, key, format); served {
+		return err
+	}
+	return writeResult(c, format, src.size, results.store(key, run))
+}
+
+// run a matrix operation on the two files uploaded as "a" and "b"
+func runBinaryOperation(c echo.Context, op matrix.BinaryOperation) error {
+	format, err := negotiateFormat(c)
+	if err != nil {
+		return err
+	}
+	if err = checkOutputFormat(c, strings.TrimPrefix(c.Path(), "/"), format); err != nil {
+		return err
+	}
+	opts, err := operationOptions(c, format)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := operationContext(c.Request().Context())
+	defer cancel()
+
+	// a raw body can only carry one matrix
+	if !isMultipart(c) {
+		return newProblem(http.StatusBadRequest, codeBadRequest, "a and b must be uploaded as multipart form files")
+	}
+	form, err := parseForm(c)
+	if err != nil {
+		return err
+	}
+	defer form.RemoveAll() // clear tmp file
+
+	a, err := openFormFile(c, form, "a")
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	b, err := openFormFile(c, form, "b")
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	key, err := resultKey(c, format, a, b)
+	if err != nil {
+		return err
+	}
+	if served, err := results.serve(c, key, format); served {
+		return err
+	}
+	return writeResult(c, format, a.size+b.size, results.store(key, func(w io.Writer) error {
+		return op(ctx, a.Input, b.Input, w, opts...)
+	}))
+}
+
+// options of the matrix operations for the request, from the output format
+// and the ?numeric=, ?mod=, ?precision=, ?rounding=, ?places= and ?max_findings=
+// query params
+func operationOptions(c echo.Context, format matrix.Format) ([]matrix.Option, error) {
+	opts := []matrix.Option{
+		matrix.WithTempDir(tempDir),
+		matrix.WithOutputFormat(format),
+		matrix.WithBufferSizes(readBuffer, writeBuffer),
+		matrix.WithMemoryBudget(memoryBudget),
+		matrix.WithConcurrency(concurrency),
+		matrix.WithMaxRows(maxRows),
+		matrix.WithMaxColumns(maxColumns),
+		matrix.WithMaxCellLength(maxCellLength),
+		matrix.WithMaxResultDigits(maxResultDigits),
+	}
+	opts = append(opts, metrics.options(strings.TrimPrefix(c.Path(), "/"), nil)...)
+
+	numeric := matrix.Int
+	if name := c.QueryParam("numeric"); name != "" {
+		var err error
+		if numeric, err = matrix.ParseNumeric(name); err != nil {
+			return nil, newProblem(http.StatusBadRequest, codeInvalidParameter, "%v", err)
+		}
+		opts = append(opts, matrix.WithNumeric(numeric))
+	}
+	if value := c.QueryParam("mod"); value != "" {
+		n, ok := new(big.Int).SetString(value, 10)
+		if !ok || n.Sign() <= 0 {
+			return nil, newProblem(http.StatusBadRequest, codeInvalidParameter, "invalid mod %q, expected a positive integer", value)
+		}
+		if numeric != matrix.Int {
+			return nil, newProblem(http.StatusBadRequest, codeInvalidParameter, "mod requires the int numeric mode, not %s", numeric)
+		}
+		opts = append(opts, matrix.WithModulus(n))
+	}
+	if value := c.QueryParam("precision"); value != "" {
+		bits, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || bits == 0 || uint(bits) > maxPrecision {
+			return nil, newProblem(http.StatusBadRequest, codeInvalidParameter, "invalid precision %q, expected a number of bits between 1 and %d", value, maxPrecision)
+		}
+		opts = append(opts, matrix.WithPrecision(uint(bits)))
+	}
+	if name := c.QueryParam("rounding"); name != "" {
+		mode, err := matrix.ParseRounding(name)
+		if err != nil {
+			return nil, newProblem(http.StatusBadRequest, codeInvalidParameter, "%v", err)
+		}
+		opts = append(opts, matrix.WithRounding(mode))
+	}
+	if value := c.QueryParam("places"); value != "" {
+		places, err := strconv.Atoi(value)
+		if err != nil || places < 0 || places > maxPlaces {
+			return nil, newProblem(http.StatusBadRequest, codeInvalidParameter, "invalid places %q, expected a number of decimals between 0 and %d", value, maxPlaces)
+		}
+		opts = append(opts, matrix.WithDecimalPlaces(places))
+	}
+	if value := c.QueryParam("max_findings"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxFindings {
+			return nil, newProblem(http.StatusBadRequest, codeInvalidParameter, "invalid max_findings %q, expected a number between 1 and %d", value, maxFindings)
+		}
+		opts = append(opts, matrix.WithMaxFindings(n))
+	}
+	return opts, nil
+}
+
+// operationContext bounds an operation to maxProcessTime, it is counted in
+// flight until cancel is called
+func operationContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(parent, maxProcessTime)
+	metrics.inFlight.Add(1)
+	return ctx, func() {
+		metrics.inFlight.Add(-1)
+		cancel()
+	}
 }
 
-func validateFileType(fileHeader *multipart.FileHeader) error {
-	if ext := strings.ToLower(filepath.Ext(fileHeader.Filename)); ext != ".csv" {
-		logger.Errorf("File type %s is not supported", ext)
-		return fmt.Errorf("only csv file supported")
+// translate an error returned by the matrix package to an HTTP error
+func operationError(err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		// context timeout, set status to 504
+		logger.Errorf("Processing matrix timeout")
+		metrics.timeouts.Add(1)
+		return newProblem(http.StatusGatewayTimeout, codeTimeout, "Processing timeout")
+	case errors.Is(err, context.Canceled):
+		// client has gone, nobody is waiting for the response
+		return nil
+	case matrix.IsInputError(err):
+		// answered by problemOf with its code and location, 422 if singular
+		logger.Errorf("invalid matrix: %v", err)
+		return err
+	default:
+		logger.Errorf("fail to process matrix: %v", err)
+		return newProblem(http.StatusInternalServerError, codeInternal, "processing error: %v", err)
 	}
-	return nil
 }
 
 // Fetch fileHeader from multipart form to support stream read
-func fetchFileHeader(form *multipart.Form) (*multipart.FileHeader, error) {
-	files := form.File["file"]
+func fetchFileHeader(form *multipart.Form, field string) (*multipart.FileHeader, error) {
+	files := form.File[field]
 	if len(files) == 0 {
-		logger.Error("File not found in the form")
-		return nil, errors.New("no files found in the form")
+		logger.Errorf("File %s not found in the form", field)
+		return nil, newProblem(http.StatusBadRequest, codeBadRequest, "no %s file found in the form", field)
 	}
 	fileHeader := files[0]
 	if fileHeader.Size == 0 {
 		logger.Error("File is empty")
-		return nil, errors.New("empty file")
-	}
-	if err := validateFileType(fileHeader); err != nil {
-		return nil, err
+		return nil, newProblem(http.StatusBadRequest, codeEmptyFile, "empty file")
 	}
 	return fileHeader, nil
 }
 
-// wrap source file and response
-func prepareReaderWriter(c echo.Context, form *multipart.Form) (multipart.File, *echo.Response, error) {
-	// get CSV handler
-	fileHeader, err := fetchFileHeader(form)
+// open the file uploaded in the given form field and detect its format
+func openFormFile(c echo.Context, form *multipart.Form, field string) (*upload, error) {
+	fileHeader, err := fetchFileHeader(form, field)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+	format, err := detectInputFormat(c, fileHeader.Filename, fileHeader.Header.Get(echo.HeaderContentType))
+	if err != nil {
+		return nil, err
 	}
 
 	// open file stream (not load into memory)
 	srcFile, err := fileHeader.Open()
 	if err != nil {
-		logger.Error("failed to open source file: %v", err)
-		return nil, nil, errors.New("fail to open file: " + err.Error())
+		logger.Errorf("failed to open source file: %v", err)
+		return nil, newProblem(http.StatusBadRequest, codeBadRequest, "fail to open file: %v", err)
 	}
+	return &upload{Input: matrix.Input{Reader: srcFile, Format: format}, file: srcFile, size: fileHeader.Size}, nil
+}
 
-	// config stream response header
+// config stream response header
+func streamResponse(c echo.Context, format matrix.Format) *echo.Response {
 	resp := c.Response()
-	resp.Header().Set(echo.HeaderContentType, "text/csv")
+	resp.Header().Set(echo.HeaderContentType, format.ContentType())
 	resp.Header().Set(echo.HeaderContentEncoding, "chunked")
 	logger.Debug("set response to stream output mode")
+	return resp
+}
+
+// upload is the matrix sent by the client, either as the "file" field of a
+// multipart form or as the raw request body
+type upload struct {
+	matrix.Input
+	file io.Closer
+	form *multipart.Form
+	size int64 // -1 when the body is sent without Content-Length
+}
+
+// Close closes the uploaded file and removes the files spooled by the form
+func (u *upload) Close() error {
+	var err error
+	if u.file != nil {
+		err = u.file.Close()
+	}
+	if u.form != nil {
+		if rerr := u.form.RemoveAll(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// isMultipart reports whether the request body is a multipart form
+func isMultipart(c echo.Context) bool {
+	mediaType, _, err := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	return err == nil && mediaType == echo.MIMEMultipartForm
+}
+
+// openUpload opens the uploaded matrix. A multipart form is spooled by
+// MultipartForm before it can be read, while a raw body is streamed to the
+// operation as it arrives.
+func openUpload(c echo.Context) (*upload, error) {
+	if isMultipart(c) {
+		form, err := parseForm(c)
+		if err != nil {
+			return nil, err
+		}
+		src, err := openFormFile(c, form, "file")
+		if err != nil {
+			form.RemoveAll() // clear tmp file
+			return nil, err
+		}
+		src.form = form
+		return src, nil
+	}
+	return openBody(c)
+}
 
-	return srcFile, resp, nil
+// openBody streams the raw request body, its format is given by ?input= or
+// by the Content-Type header, CSV when neither is set
+func openBody(c echo.Context) (*upload, error) {
+	req := c.Request()
+	inputFormat, err := detectBodyFormat(c, req.Header.Get(echo.HeaderContentType))
+	if err != nil {
+		return nil, err
+	}
+
+	// detect empty body without consuming it
+	body := bufio.NewReader(req.Body)
+	if _, err := body.Peek(1); err != nil {
+		if errors.Is(err, io.EOF) {
+			logger.Error("Request body is empty")
+			return nil, newProblem(http.StatusBadRequest, codeEmptyFile, "empty file")
+		}
+		return nil, newProblem(http.StatusBadRequest, codeBadRequest, "fail to read body: %v", err)
+	}
+
+	// by default the HTTP/1 server stops reading the body once the response
+	// is started, results are streamed while the body is still being read
+	if err := http.NewResponseController(c.Response()).EnableFullDuplex(); err != nil {
+		logger.Debugf("full duplex not supported: %v", err)
+	}
+	return &upload{Input: matrix.Input{Reader: body, Format: inputFormat}, size: req.ContentLength}, nil
 }
@@ -0,0 +1,179 @@
+package matrix
+
+import (
+	"math/big"
+	"math/bits"
+	"strconv"
+)
+
+// intSum adds integers on native words: the positive and the negative cells
+// are summed apart as uint64 magnitudes, which are carried into a big.Int
+// only when they overflow. The zero value is a sum of 0.
+type intSum struct {
+	pos, neg uint64
+	carry    *big.Int // nil until a magnitude overflows or a cell exceeds int64
+	tmp      big.Int
+}
+
+// addCell parses cell and adds it to the sum
+func (s *intSum) addCell(cell string) error {
+	x, err := strconv.ParseInt(cell, 10, 64)
+	if err != nil {
+		// beyond the int64 range, surrounded by spaces or not a number at all
+		if err = parseInt(&s.tmp, cell); err != nil {
+			return err
+		}
+		s.addBig(&s.tmp)
+		return nil
+	}
+	if x >= 0 {
+		s.addPos(uint64(x))
+	} else {
+		s.addNeg(uint64(-x)) // -MinInt64 wraps to MinInt64, whose uint64 is its magnitude
+	}
+	return nil
+}
+
+func (s *intSum) addPos(x uint64) {
+	sum, carry := bits.Add64(s.pos, x, 0)
+	if carry != 0 {
+		s.addBig(s.tmp.SetUint64(s.pos))
+		sum = x
+	}
+	s.pos = sum
+}
+
+func (s *intSum) addNeg(x uint64) {
+	sum, carry := bits.Add64(s.neg, x, 0)
+	if carry != 0 {
+		s.addBig(s.tmp.SetUint64(s.neg).Neg(&s.tmp))
+		sum = x
+	}
+	s.neg = sum
+}
+
+func (s *intSum) addBig(x *big.Int) {
+	if s.carry == nil {
+		s.carry = new(big.Int)
+	}
+	s.carry.Add(s.carry, x)
+}
+
+// merge adds the partial sum o
+func (s *intSum) merge(o *intSum) {
+	s.addPos(o.pos)
+	s.addNeg(o.neg)
+	if o.carry != nil {
+		s.addBig(o.carry)
+	}
+}
+
+// Int returns the sum
+func (s *intSum) Int() *big.Int {
+	sum := new(big.Int).SetUint64(s.pos)
+	if s.carry != nil {
+		sum.Add(sum, s.carry)
+	}
+	return sum.Sub(sum, s.tmp.SetUint64(s.neg))
+}
+
+// intProduct multiplies integers on native words: the magnitude of the
+// product is kept in a uint64 until it overflows, then the full word is set
+// aside and multiplied with the others once at the end, as a balanced product
+// tree which is much cheaper than growing a big.Int cell after cell.
+type intProduct struct {
+	mag   uint64     // magnitude of the product of the cells since the last overflow
+	words []uint64   // magnitudes set aside on overflow
+	bigs  []*big.Int // magnitudes of the cells exceeding int64
+	neg   bool
+	zero  bool
+	// bits the product has at least, from the factors set aside
+	minBits int
+}
+
+func newIntProduct() *intProduct {
+	return &intProduct{mag: 1}
+}
+
+// mulCell parses cell and multiplies the product by it
+func (p *intProduct) mulCell(cell string) error {
+	x, err := strconv.ParseInt(cell, 10, 64)
+	if err != nil {
+		// beyond the int64 range, surrounded by spaces or not a number at all
+		z := new(big.Int)
+		if err = parseInt(z, cell); err != nil {
+			return err
+		}
+		switch z.Sign() {
+		case 0:
+			p.zero = true
+		case -1:
+			p.neg = !p.neg
+			z.Neg(z)
+		}
+		p.bigs = append(p.bigs, z)
+		p.minBits += z.BitLen() - 1
+		return nil
+	}
+	switch {
+	case x == 0:
+		p.zero = true
+	case x < 0:
+		p.neg = !p.neg
+		p.mulMag(uint64(-x))
+	default:
+		p.mulMag(uint64(x))
+	}
+	return nil
+}
+
+func (p *intProduct) mulMag(x uint64) {
+	hi, lo := bits.Mul64(p.mag, x)
+	if hi != 0 {
+		p.words = append(p.words, p.mag)
+		p.minBits += bits.Len64(p.mag) - 1
+		lo = x
+	}
+	p.mag = lo
+}
+
+// merge multiplies by the partial product o
+func (p *intProduct) merge(o *intProduct) {
+	p.zero = p.zero || o.zero
+	p.neg = p.neg != o.neg
+	p.mulMag(o.mag)
+	p.words = append(p.words, o.words...)
+	p.bigs = append(p.bigs, o.bigs...)
+	p.minBits += o.minBits
+}
+
+// Int returns the product
+func (p *intProduct) Int() *big.Int {
+	if p.zero {
+		return new(big.Int)
+	}
+	factors := make([]*big.Int, 0, len(p.words)+len(p.bigs)+1)
+	factors = append(factors, new(big.Int).SetUint64(p.mag))
+	for _, w := range p.words {
+		factors = append(factors, new(big.Int).SetUint64(w))
+	}
+	factors = append(factors, p.bigs...)
+
+	// multiply pairs of factors of similar sizes until one is left
+	for len(factors) > 1 {
+		n := 0
+		for i := 0; i < len(factors); i += 2 {
+			if i+1 < len(factors) {
+				factors[n] = factors[i].Mul(factors[i], factors[i+1])
+			} else {
+				factors[n] = factors[i]
+			}
+			n++
+		}
+		factors = factors[:n]
+	}
+	if p.neg {
+		factors[0].Neg(factors[0])
+	}
+	return factors[0]
+}
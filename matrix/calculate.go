@@ -0,0 +1,200 @@
+package matrix
+
+import (
+	"context"
+	"io"
+	"math/big"
+	"strings"
+)
+
+// parseInt parses one cell into z, reporting ErrNotNumber for anything that is
+// not a base 10 integer
+func parseInt(z *big.Int, cell string) error {
+	if _, succ := z.SetString(strings.TrimSpace(cell), 10); !succ {
+		return notNumber(cell)
+	}
+	return nil
+}
+
+// Sum writes the sum of all the numbers of the matrix read from src to dst.
+func Sum(ctx context.Context, src io.Reader, dst io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	var sum string
+	if m, ok, err := sparseInput(src, cfg, true); ok {
+		if err != nil {
+			return err
+		}
+		if sum, err = newEngine(cfg).sumSparse(ctx, m); err != nil {
+			return err
+		}
+		return writeScalar(dst, cfg, sum)
+	}
+
+	sum, err := newEngine(cfg).sum(ctx, newReader(ctx, src, cfg))
+	if err != nil {
+		return err
+	}
+	return writeScalar(dst, cfg, sum)
+}
+
+// sumOf adds up the numbers read, partial sums of chunks of rows are computed
+// by the workers and merged
+func sumOf[T any](ctx context.Context, reader *Reader, ar arithmetic[T], workers int) (T, error) {
+	sum := ar.zero()
+	err := pipeline(ctx, reader, chunkRows, workers, func(first int, rows [][]string) (T, error) {
+		return sumRows(first, rows, ar)
+	}, func(partial T) error {
+		sum = ar.add(sum, sum, partial)
+		return nil
+	})
+	return sum, err
+}
+
+// sumRows adds up the numbers of a chunk of rows, first rows after the start
+func sumRows[T any](first int, rows [][]string, ar arithmetic[T]) (T, error) {
+	// reuse the numbers to save the memory
+	sum, tmp := ar.zero(), ar.zero()
+	var err error
+	for i, record := range rows {
+		for j, num := range record {
+			// validate format of the input, make sure all of them are valid number
+			if tmp, err = ar.parse(tmp, num); err != nil {
+				return sum, locate(err, first+i+1, j+1)
+			}
+			// big numbers handle huge file scenario, prevent from mathematics overflow
+			sum = ar.add(sum, sum, tmp)
+		}
+	}
+	return sum, nil
+}
+
+// sumInts is sumOf for the int mode, summing on native integers until they
+// overflow
+func sumInts(ctx context.Context, reader *Reader, workers int) (*big.Int, error) {
+	var sum intSum
+	err := pipeline(ctx, reader, chunkRows, workers, func(first int, rows [][]string) (*intSum, error) {
+		partial := new(intSum)
+		for i, record := range rows {
+			for j, num := range record {
+				if err := partial.addCell(num); err != nil {
+					return nil, locate(err, first+i+1, j+1)
+				}
+			}
+		}
+		return partial, nil
+	}, func(partial *intSum) error {
+		sum.merge(partial)
+		return nil
+	})
+	return sum.Int(), err
+}
+
+// Multiply writes the product of all the numbers of the matrix read from src
+// to dst.
+func Multiply(ctx context.Context, src io.Reader, dst io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	var product string
+	if m, ok, err := sparseInput(src, cfg, true); ok {
+		if err != nil {
+			return err
+		}
+		if product, err = newEngine(cfg).productSparse(ctx, m); err != nil {
+			return err
+		}
+		return writeScalar(dst, cfg, product)
+	}
+
+	product, err := newEngine(cfg).product(ctx, newReader(ctx, src, cfg))
+	if err != nil {
+		return err
+	}
+	return writeScalar(dst, cfg, product)
+}
+
+// productOf multiplies the numbers read, partial products of chunks of rows
+// are computed by the workers and merged
+func productOf[T any](ctx context.Context, reader *Reader, ar arithmetic[T], workers int, l limits) (T, error) {
+	product := ar.setInt64(ar.zero(), 1)
+	err := pipeline(ctx, reader, chunkRows, workers, func(first int, rows [][]string) (T, error) {
+		return multiplyRows(first, rows, ar, l)
+	}, func(partial T) error {
+		product = ar.mul(product, product, partial)
+		// once it equals 0, the rows left are neither read nor checked
+		if ar.sign(product) == 0 {
+			return errSkipRest
+		}
+		// give up before the product outgrows the limit rather than once computed
+		return l.checkBits(ar.bits(product))
+	})
+	return product, err
+}
+
+// multiplyRows multiplies the numbers of a chunk of rows, first rows after the
+// start, a partial product outgrowing the limits is reported once its row is
+// multiplied
+func multiplyRows[T any](first int, rows [][]string, ar arithmetic[T], l limits) (T, error) {
+	product, tmp := ar.setInt64(ar.zero(), 1), ar.zero()
+	var err error
+	for i, record := range rows {
+		for j, num := range record {
+			if tmp, err = ar.parse(tmp, num); err != nil {
+				return product, locate(err, first+i+1, j+1)
+			}
+			product = ar.mul(product, product, tmp)
+
+			// optimize the loop, once it equals 0, the rest of the chunk is skipped
+			if ar.sign(product) == 0 {
+				return product, nil
+			}
+		}
+		if err = l.checkBits(ar.bits(product)); err != nil {
+			return product, err
+		}
+	}
+	return product, nil
+}
+
+// productInts is productOf for the int mode, multiplying on native integers
+// until they overflow
+func productInts(ctx context.Context, reader *Reader, workers int, l limits) (*big.Int, error) {
+	product := newIntProduct()
+	err := pipeline(ctx, reader, chunkRows, workers, func(first int, rows [][]string) (*intProduct, error) {
+		partial := newIntProduct()
+		for i, record := range rows {
+			for j, num := range record {
+				if err := partial.mulCell(num); err != nil {
+					return nil, locate(err, first+i+1, j+1)
+				}
+				// once it equals 0, the rest of the chunk is skipped
+				if partial.zero {
+					return partial, nil
+				}
+			}
+		}
+		return partial, nil
+	}, func(partial *intProduct) error {
+		product.merge(partial)
+		// once it equals 0, the rows left are neither read nor checked
+		if product.zero {
+			return errSkipRest
+		}
+		// give up before the product outgrows the limit rather than once computed
+		return l.checkBits(product.minBits)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return product.Int(), nil
+}
+
+// writeScalar writes a single value result as a one cell matrix
+func writeScalar(dst io.Writer, cfg *config, value string) error {
+	if err := cfg.limits.checkResult(value); err != nil {
+		return err
+	}
+	w := cfg.rowWriter(dst)
+	if err := w.WriteRow([]string{value}); err != nil {
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,95 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// no of rows written between two flushes to the client
+const flushRows = 1000
+
+// flusher is implemented by writers able to push buffered data to a client,
+// such as http.ResponseWriter
+type flusher interface {
+	Flush()
+}
+
+// Echo writes the square matrix read from src back to dst.
+func Echo(ctx context.Context, src io.Reader, dst io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	if m, ok, err := sparseInput(src, cfg, false); ok {
+		if err != nil {
+			return err
+		}
+		if m.header.rows != m.header.cols {
+			return newInputError(ErrNotSquare, "Not a matrix: line: %d, columns: %d", m.header.rows, m.header.cols)
+		}
+		return copySparse(ctx, m, dst, cfg, false)
+	}
+
+	reader := newReader(ctx, src, cfg)
+	reader.RequireSquare = true
+
+	w := cfg.rowWriter(dst)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return w.Close() // normal ended
+			}
+			return err
+		}
+
+		if err = w.WriteRow(record); err != nil {
+			return err
+		}
+
+		// flush to the client every 1000 rows
+		if reader.Rows()%flushRows == 0 {
+			// ** once this is executed on an HTTP response, the header is committed and the status code cannot be changed anymore.
+			if err = w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Flatten writes the square matrix read from src to dst as a single row.
+func Flatten(ctx context.Context, src io.Reader, dst io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	reader := newReader(ctx, src, cfg)
+	reader.RequireSquare = true
+
+	// the cells are streamed as they come, the row is only ended with the input
+	w := cfg.rowWriter(dst)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				if reader.Rows() > 0 {
+					if err = w.EndRow(); err != nil {
+						return err
+					}
+				}
+				return w.Close() // normal ended
+			}
+			return err
+		}
+
+		if err = w.WriteCells(record); err != nil {
+			return err
+		}
+		if reader.Rows()%flushRows == 0 {
+			if err = w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// elementOp is the operation applied to each pair of cells
+type elementOp int
+
+const (
+	opAdd elementOp = iota
+	opSub
+	opMul
+)
+
+// Add writes the cell by cell sum A + B of two matrices of the same shape.
+func Add(ctx context.Context, a, b io.Reader, dst io.Writer, opts ...Option) error {
+	return elementwise(ctx, a, b, dst, newConfig(opts), opAdd)
+}
+
+// Subtract writes the cell by cell difference A - B of two matrices of the
+// same shape.
+func Subtract(ctx context.Context, a, b io.Reader, dst io.Writer, opts ...Option) error {
+	return elementwise(ctx, a, b, dst, newConfig(opts), opSub)
+}
+
+// Hadamard writes the cell by cell product A ∘ B of two matrices of the same
+// shape.
+func Hadamard(ctx context.Context, a, b io.Reader, dst io.Writer, opts ...Option) error {
+	return elementwise(ctx, a, b, dst, newConfig(opts), opMul)
+}
+
+func elementwise(ctx context.Context, a, b io.Reader, dst io.Writer, cfg *config, op elementOp) error {
+	w := cfg.resultWriter(dst)
+	if err := newEngine(cfg).elementwise(ctx, newReader(ctx, a, cfg), newReader(ctx, b, cfg), w, op); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// elementwiseOf streams both matrices row by row in lockstep and writes op
+// applied to each pair of cells
+func elementwiseOf[T any](ctx context.Context, aReader, bReader *Reader, w RowWriter, ar arithmetic[T], op elementOp) error {
+	fn := ar.add
+	switch op {
+	case opSub:
+		fn = ar.sub
+	case opMul:
+		fn = ar.mul
+	}
+
+	var row []string
+	x, y := ar.zero(), ar.zero()
+	var err error
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		aRecord, aErr := aReader.Read()
+		if aErr != nil && !errors.Is(aErr, io.EOF) {
+			return aErr
+		}
+		bRecord, bErr := bReader.Read()
+		if bErr != nil && !errors.Is(bErr, io.EOF) {
+			return bErr
+		}
+
+		// both matrices must end on the same line
+		if aErr != nil || bErr != nil {
+			if aErr == nil {
+				return newInputError(ErrDimensionMismatch, "row number inconsistent: line: %d of a expects %d rows", aReader.Rows(), bReader.Rows())
+			}
+			if bErr == nil {
+				return newInputError(ErrDimensionMismatch, "row number inconsistent: line: %d of b expects %d rows", bReader.Rows(), aReader.Rows())
+			}
+			return nil // normal ended
+		}
+
+		if len(bRecord) != len(aRecord) {
+			err := newInputError(ErrDimensionMismatch, "column number inconsistent: row: %d expects %d colums", bReader.Rows(), len(aRecord))
+			err.Row = bReader.Rows()
+			return err
+		}
+
+		row = row[:0]
+		for i := range aRecord {
+			if x, err = ar.parse(x, aRecord[i]); err != nil {
+				return locate(err, aReader.Rows(), i+1)
+			}
+			if y, err = ar.parse(y, bRecord[i]); err != nil {
+				return locate(err, bReader.Rows(), i+1)
+			}
+			x = fn(x, x, y)
+			row = append(row, ar.format(x))
+		}
+		if err = w.WriteRow(row); err != nil {
+			return err
+		}
+
+		// flush to the client every 1000 rows
+		if aReader.Rows()%flushRows == 0 {
+			if err = w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package matrix
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors reported for invalid input. Use errors.Is to test for them; the
+// returned errors carry a message describing where the problem is.
+var (
+	ErrSyntax    = errors.New("parsing error")
+	ErrRaggedRow = errors.New("column number inconsistent")
+	ErrNotSquare = errors.New("not a matrix")
+	ErrNotNumber = errors.New("not a number")
+
+	ErrDimensionMismatch = errors.New("dimension mismatch")
+	ErrSingular          = errors.New("singular matrix")
+
+	// ErrTooLarge is reported when the input goes beyond a limit such as
+	// WithMaxRows, and ErrResultTooLarge when the result goes beyond
+	// WithMaxResultDigits.
+	ErrTooLarge       = errors.New("input too large")
+	ErrResultTooLarge = errors.New("result too large")
+)
+
+// Code is the stable, machine readable identifier of the kind of an Error.
+type Code string
+
+const (
+	CodeSyntax            Code = "SYNTAX_ERROR"
+	CodeRaggedRow         Code = "RAGGED_ROW"
+	CodeNotSquare         Code = "NOT_SQUARE"
+	CodeNotNumber         Code = "NOT_A_NUMBER"
+	CodeDimensionMismatch Code = "DIMENSION_MISMATCH"
+	CodeSingular          Code = "SINGULAR"
+	CodeTooManyRows       Code = "TOO_MANY_ROWS"
+	CodeTooManyColumns    Code = "TOO_MANY_COLUMNS"
+	CodeCellTooLong       Code = "CELL_TOO_LONG"
+	CodeTooManyCells      Code = "TOO_MANY_CELLS"
+	CodeResultTooLarge    Code = "RESULT_TOO_LARGE"
+
+	// problems reported by Validate only, the operations read through them
+	CodeBlankLine         Code = "BLANK_LINE"
+	CodeBOM               Code = "BOM"
+	CodeTrailingDelimiter Code = "TRAILING_DELIMITER"
+)
+
+// codes maps the sentinel errors to their code
+var codes = map[error]Code{
+	ErrSyntax:            CodeSyntax,
+	ErrRaggedRow:         CodeRaggedRow,
+	ErrNotSquare:         CodeNotSquare,
+	ErrNotNumber:         CodeNotNumber,
+	ErrDimensionMismatch: CodeDimensionMismatch,
+	ErrSingular:          CodeSingular,
+	ErrResultTooLarge:    CodeResultTooLarge,
+}
+
+// Error is the error returned for invalid input, it wraps one of the sentinel
+// errors and locates the problem when it is about one row or one cell. Use
+// errors.As to get at it.
+type Error struct {
+	Code Code
+	// Row and Col are the 1-based row and column of the problem, 0 when the
+	// problem is not about one row or one cell.
+	Row int
+	Col int
+	// Token is the offending cell, if any.
+	Token string
+
+	kind  error
+	cause error // error of the underlying reader, for a syntax error
+	msg   string
+}
+
+func newInputError(kind error, format string, args ...any) *Error {
+	return &Error{Code: codes[kind], kind: kind, msg: fmt.Sprintf(format, args...)}
+}
+
+// notNumber reports a cell that cannot be parsed, located by the caller
+func notNumber(cell string) *Error {
+	err := newInputError(ErrNotNumber, "%s is not a number", cell)
+	err.Token = cell
+	return err
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+func (e *Error) Unwrap() []error {
+	if e.cause != nil {
+		return []error{e.kind, e.cause}
+	}
+	return []error{e.kind}
+}
+
+// locate sets the position of the cell at row and col on an Error that has
+// none yet, other errors are returned as they are
+func locate(err error, row, col int) error {
+	var e *Error
+	if errors.As(err, &e) && e.Row == 0 {
+		e.Row, e.Col = row, col
+		e.msg += fmt.Sprintf(" at row %d, column %d", row, col)
+	}
+	return err
+}
+
+// IsInputError reports whether err was caused by invalid input rather than by
+// an I/O failure or a cancelled context.
+func IsInputError(err error) bool {
+	var e *Error
+	return errors.As(err, &e)
+}
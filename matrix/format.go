@@ -0,0 +1,374 @@
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Format is an encoding of the rows of a matrix.
+type Format string
+
+const (
+	CSV    Format = "csv"
+	TSV    Format = "tsv"
+	JSON   Format = "json"   // a single array of row arrays
+	NDJSON Format = "ndjson" // one row array per line
+	Text   Format = "txt"    // cells separated by spaces or tabs
+
+	// MatrixMarket is the coordinate or array format of sparse matrices,
+	// results are written in the coordinate layout.
+	MatrixMarket Format = "mtx"
+)
+
+// Formats lists the supported formats.
+var Formats = []Format{CSV, TSV, JSON, NDJSON, Text, MatrixMarket}
+
+// ParseFormat returns the format named s, such as "csv" or "json".
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if strings.EqualFold(s, string(f)) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported format %q, expected one of %s", s, formatNames(Formats))
+}
+
+// ContentType returns the media type of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case TSV:
+		return "text/tab-separated-values"
+	case JSON:
+		return "application/json"
+	case NDJSON:
+		return "application/x-ndjson"
+	case Text:
+		return "text/plain"
+	case MatrixMarket:
+		return "application/x-matrix-market"
+	default:
+		return "text/csv"
+	}
+}
+
+func formatNames(formats []Format) string {
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = string(f)
+	}
+	return strings.Join(names, ", ")
+}
+
+// RowWriter writes the rows of a result in one output format. Rows can be
+// written at once with WriteRow or cell by cell with WriteCells and EndRow,
+// which lets a single huge row be streamed.
+type RowWriter interface {
+	// WriteRow writes a complete row.
+	WriteRow(row []string) error
+	// WriteCells appends cells to the current row.
+	WriteCells(cells []string) error
+	// EndRow terminates the current row.
+	EndRow() error
+	// Flush pushes the buffered rows to the underlying writer, and to the
+	// client when the writer is an HTTP response.
+	Flush() error
+	// Close terminates the document and flushes it.
+	Close() error
+}
+
+// NewRowWriter returns a buffered RowWriter encoding rows to w in format f.
+func NewRowWriter(w io.Writer, f Format) RowWriter {
+	return newRowWriter(w, f, writeBufferSize)
+}
+
+// newRowWriter is NewRowWriter with a write buffer of size bytes
+func newRowWriter(w io.Writer, f Format, size int) RowWriter {
+	base := rowBuffer{dst: w, w: &rowBuf{dst: w, size: size}}
+	switch f {
+	case TSV:
+		return &delimitedWriter{rowBuffer: base, comma: '\t'}
+	case JSON:
+		return &jsonWriter{rowBuffer: base}
+	case NDJSON:
+		return &jsonWriter{rowBuffer: base, lines: true}
+	case Text:
+		return &delimitedWriter{rowBuffer: base, comma: ' '}
+	case MatrixMarket:
+		return &mtxWriter{dst: w, size: size, budget: defaultMemoryBudget}
+	default:
+		return &delimitedWriter{rowBuffer: base, comma: ','}
+	}
+}
+
+// rowWriter returns the RowWriter of the result, MatrixMarket entries are
+// spilled into the temporary directory beyond the memory budget
+func (c *config) rowWriter(dst io.Writer) RowWriter {
+	w := newRowWriter(dst, c.format, c.writeBuffer)
+	if m, ok := w.(*mtxWriter); ok {
+		m.budget, m.tempDir = c.memoryBudget, c.tempDir
+	}
+	return w
+}
+
+// rowBuffer holds the buffer shared by the writers
+type rowBuffer struct {
+	dst   io.Writer
+	w     *rowBuf
+	cells int // cells written in the current row
+}
+
+// rowBuf buffers the encoded rows and only pushes complete rows to dst, so
+// that a result cut short by an error does not end in the middle of a row.
+// A row larger than the buffer is pushed as it comes.
+type rowBuf struct {
+	dst    io.Writer
+	size   int
+	buf    []byte
+	rowEnd int // bytes of buf holding complete rows
+	err    error
+}
+
+func (b *rowBuf) WriteByte(c byte) error {
+	err := b.push()
+	b.buf = append(b.buf, c)
+	return err
+}
+
+func (b *rowBuf) WriteString(s string) (int, error) {
+	err := b.push()
+	b.buf = append(b.buf, s...)
+	return len(s), err
+}
+
+func (b *rowBuf) Write(p []byte) (int, error) {
+	err := b.push()
+	b.buf = append(b.buf, p...)
+	return len(p), err
+}
+
+// endRow marks the end of a row
+func (b *rowBuf) endRow() {
+	b.rowEnd = len(b.buf)
+}
+
+// push writes the complete rows once the buffer is full, or all of it when it
+// holds none
+func (b *rowBuf) push() error {
+	if len(b.buf) < b.size {
+		return b.err
+	}
+	if b.rowEnd > 0 {
+		return b.write(b.rowEnd)
+	}
+	return b.write(len(b.buf))
+}
+
+// write writes the first n bytes of the buffer
+func (b *rowBuf) write(n int) error {
+	if b.err != nil {
+		return b.err
+	}
+	if n == 0 {
+		return nil
+	}
+	if _, err := b.dst.Write(b.buf[:n]); err != nil {
+		b.err = err
+		return err
+	}
+	b.buf = b.buf[:copy(b.buf, b.buf[n:])]
+	b.rowEnd = max(b.rowEnd-n, 0)
+	return nil
+}
+
+func (b *rowBuf) Flush() error {
+	return b.write(len(b.buf))
+}
+
+func (b *rowBuffer) Flush() error {
+	if err := b.w.Flush(); err != nil {
+		return err
+	}
+	if f, ok := b.dst.(flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// delimitedWriter writes CSV or TSV, quoting cells the same way encoding/csv
+// does
+type delimitedWriter struct {
+	rowBuffer
+	comma byte
+}
+
+func (d *delimitedWriter) WriteRow(row []string) error {
+	if err := d.WriteCells(row); err != nil {
+		return err
+	}
+	return d.EndRow()
+}
+
+func (d *delimitedWriter) WriteCells(cells []string) error {
+	for _, cell := range cells {
+		if d.cells > 0 {
+			d.w.WriteByte(d.comma)
+		}
+		d.cells++
+		if !d.needsQuotes(cell) {
+			d.w.WriteString(cell)
+			continue
+		}
+		d.w.WriteByte('"')
+		d.w.WriteString(strings.ReplaceAll(cell, `"`, `""`))
+		if err := d.w.WriteByte('"'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *delimitedWriter) needsQuotes(cell string) bool {
+	if cell == "" {
+		return false
+	}
+	if cell == `\.` || strings.IndexByte(cell, d.comma) >= 0 || strings.ContainsAny(cell, "\"\r\n") {
+		return true
+	}
+	return cell[0] == ' ' || cell[0] == '\t'
+}
+
+func (d *delimitedWriter) EndRow() error {
+	d.cells = 0
+	err := d.w.WriteByte('\n')
+	d.w.endRow()
+	return err
+}
+
+func (d *delimitedWriter) Close() error {
+	return d.Flush()
+}
+
+// jsonWriter streams the rows as JSON arrays, either inside one top level
+// array or one per line. Numeric cells are written as JSON numbers, anything
+// else (such as fractions) as strings.
+type jsonWriter struct {
+	rowBuffer
+	lines   bool
+	rows    int
+	started bool
+	inRow   bool
+}
+
+func (j *jsonWriter) WriteRow(row []string) error {
+	if err := j.WriteCells(row); err != nil {
+		return err
+	}
+	return j.EndRow()
+}
+
+// openRow starts the document and the current row when needed
+func (j *jsonWriter) openRow() {
+	if !j.started && !j.lines {
+		j.w.WriteByte('[')
+	}
+	j.started = true
+	if !j.inRow {
+		if j.rows > 0 && !j.lines {
+			j.w.WriteByte(',')
+		}
+		j.w.WriteByte('[')
+		j.inRow = true
+	}
+}
+
+func (j *jsonWriter) WriteCells(cells []string) error {
+	j.openRow()
+	for _, cell := range cells {
+		if j.cells > 0 {
+			j.w.WriteByte(',')
+		}
+		j.cells++
+		if isJSONNumber(cell) {
+			j.w.WriteString(cell)
+			continue
+		}
+		quoted, err := json.Marshal(cell)
+		if err != nil {
+			return err
+		}
+		if _, err = j.w.Write(quoted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *jsonWriter) EndRow() error {
+	j.openRow()
+	j.w.WriteByte(']')
+	if j.lines {
+		j.w.WriteByte('\n')
+	}
+	j.w.endRow()
+	j.inRow = false
+	j.cells = 0
+	j.rows++
+	return nil
+}
+
+func (j *jsonWriter) Close() error {
+	if !j.lines {
+		if !j.started {
+			j.w.WriteByte('[')
+		}
+		j.w.WriteString("]\n")
+	}
+	return j.Flush()
+}
+
+// isJSONNumber reports whether s follows the JSON number grammar
+func isJSONNumber(s string) bool {
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	// integer part, no leading zero
+	switch {
+	case i < len(s) && s[i] == '0':
+		i++
+	case i < len(s) && s[i] >= '1' && s[i] <= '9':
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+	default:
+		return false
+	}
+	// fraction
+	if i < len(s) && s[i] == '.' {
+		i++
+		start := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == start {
+			return false
+		}
+	}
+	// exponent
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		start := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == start {
+			return false
+		}
+	}
+	return i == len(s)
+}
@@ -0,0 +1,79 @@
+package matrix
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// jsonSource streams the rows of a JSON array of arrays, or of one array per
+// line for NDJSON, without decoding the whole document
+type jsonSource struct {
+	dec     *json.Decoder
+	lines   bool
+	started bool
+	row     int
+	cells   []any
+	record  []string
+}
+
+func newJSONSource(r io.Reader, lines bool) *jsonSource {
+	dec := json.NewDecoder(r)
+	dec.UseNumber() // keep big numbers intact
+	return &jsonSource{dec: dec, lines: lines}
+}
+
+func (j *jsonSource) Read() ([]string, error) {
+	if !j.lines {
+		// enter the top level array
+		if !j.started {
+			j.started = true
+			if err := j.expectDelim('['); err != nil {
+				return nil, err
+			}
+		}
+		if !j.dec.More() {
+			if err := j.expectDelim(']'); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+	}
+
+	j.row++
+	j.cells = j.cells[:0]
+	if err := j.dec.Decode(&j.cells); err != nil {
+		if errors.Is(err, io.EOF) && j.lines {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("row %d: %w", j.row, err)
+	}
+
+	j.record = j.record[:0]
+	for col, cell := range j.cells {
+		switch v := cell.(type) {
+		case json.Number:
+			j.record = append(j.record, v.String())
+		case string:
+			j.record = append(j.record, v)
+		default:
+			return nil, fmt.Errorf("row %d, column %d: expects a number or a string", j.row, col+1)
+		}
+	}
+	return j.record, nil
+}
+
+func (j *jsonSource) expectDelim(want json.Delim) error {
+	token, err := j.dec.Token()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if token != want {
+		return fmt.Errorf("expects %v, found %v", want, token)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package matrix
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// limits bounds what an operation reads and computes, a zero field is no
+// limit
+type limits struct {
+	rows         int
+	cols         int
+	cellLength   int
+	resultDigits int
+}
+
+// no of bytes of an overlong cell kept as the token of its error
+const tokenPrefix = 32
+
+// newLimitError reports an input going beyond a limit
+func newLimitError(code Code, format string, args ...any) *Error {
+	err := newInputError(ErrTooLarge, format, args...)
+	err.Code = code
+	return err
+}
+
+// checkRow reports the row-th record when it goes beyond the limits
+func (l limits) checkRow(record []string, row int) error {
+	if l.rows > 0 && row > l.rows {
+		err := newLimitError(CodeTooManyRows, "more than %d rows", l.rows)
+		err.Row = row
+		return err
+	}
+	if l.cols > 0 && len(record) > l.cols {
+		err := newLimitError(CodeTooManyColumns, "more than %d columns", l.cols)
+		return locate(err, row, l.cols+1)
+	}
+	if l.cellLength > 0 {
+		for j, cell := range record {
+			if len(cell) > l.cellLength {
+				err := newLimitError(CodeCellTooLong, "cell of %d bytes, more than %d", len(cell), l.cellLength)
+				err.Token = cell
+				if len(cell) > tokenPrefix {
+					err.Token = cell[:tokenPrefix] + "…"
+				}
+				return locate(err, row, j+1)
+			}
+		}
+	}
+	return nil
+}
+
+// checkShape reports a matrix whose shape, known up front, goes beyond the
+// limits
+func (l limits) checkShape(rows, cols int) error {
+	if l.rows > 0 && rows > l.rows {
+		return newLimitError(CodeTooManyRows, "%d rows, more than %d", rows, l.rows)
+	}
+	if l.cols > 0 && cols > l.cols {
+		return newLimitError(CodeTooManyColumns, "%d columns, more than %d", cols, l.cols)
+	}
+	return nil
+}
+
+// checkResult reports a number of the result with too many digits
+func (l limits) checkResult(value string) error {
+	if l.resultDigits <= 0 || len(value) <= l.resultDigits {
+		return nil
+	}
+	digits := 0
+	for i := 0; i < len(value); i++ {
+		if '0' <= value[i] && value[i] <= '9' {
+			digits++
+		}
+	}
+	if digits > l.resultDigits {
+		return newInputError(ErrResultTooLarge, "the result has %d digits, more than %d", digits, l.resultDigits)
+	}
+	return nil
+}
+
+// checkBits reports a result known to have at least bits bits
+func (l limits) checkBits(bits int) error {
+	if l.resultDigits <= 0 {
+		return nil
+	}
+	if digits := int(float64(bits) * math.Log10(2)); digits > l.resultDigits {
+		return newInputError(ErrResultTooLarge, "the result has more than %d digits, more than %d", digits, l.resultDigits)
+	}
+	return nil
+}
+
+// resultWriter returns the RowWriter of a computed result, checking that its
+// numbers fit the limits
+func (c *config) resultWriter(dst io.Writer) RowWriter {
+	w := c.rowWriter(dst)
+	if c.limits.resultDigits <= 0 {
+		return w
+	}
+	return &limitedWriter{RowWriter: w, limits: c.limits}
+}
+
+// limitedWriter checks the numbers of the rows before writing them
+type limitedWriter struct {
+	RowWriter
+	limits limits
+	row    int
+}
+
+func (w *limitedWriter) WriteRow(record []string) error {
+	w.row++
+	for j, cell := range record {
+		if err := w.limits.checkResult(cell); err != nil {
+			resultErr := err.(*Error)
+			resultErr.msg += fmt.Sprintf(" in row %d, column %d of the result", w.row, j+1)
+			return resultErr
+		}
+	}
+	return w.RowWriter.WriteRow(record)
+}
@@ -0,0 +1,251 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/big"
+)
+
+// readMatrix loads the whole matrix of reader in memory, as the elimination
+// algorithms need random access to every cell
+func readMatrix[T any](ctx context.Context, reader *Reader, ar arithmetic[T]) ([][]T, error) {
+	var m [][]T
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return m, nil
+			}
+			return nil, err
+		}
+		row := make([]T, len(record))
+		for j, num := range record {
+			if row[j], err = ar.parse(ar.zero(), num); err != nil {
+				return nil, locate(err, reader.Rows(), j+1)
+			}
+		}
+		m = append(m, row)
+	}
+}
+
+// Determinant writes the determinant of the square matrix read from src to
+// dst. It is exact unless the Decimal or Float64 numeric mode is set.
+func Determinant(ctx context.Context, src io.Reader, dst io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	reader := newReader(ctx, src, cfg)
+	reader.RequireSquare = true
+	reader.holdRows(0, cfg.memoryBudget)
+
+	det, err := newEngine(cfg).determinant(ctx, reader)
+	if err != nil {
+		return err
+	}
+	return writeScalar(dst, cfg, det)
+}
+
+// bareiss computes the determinant of the square matrix m with the fraction
+// free Bareiss elimination, every division is exact so all the intermediate
+// values stay integers. m is overwritten.
+func bareiss(ctx context.Context, m [][]*big.Int) (*big.Int, error) {
+	n := len(m)
+	if n == 0 {
+		return big.NewInt(1), nil // determinant of the empty matrix
+	}
+
+	negative := false
+	prev := big.NewInt(1)
+	t1, t2 := new(big.Int), new(big.Int)
+	for k := 0; k < n-1; k++ {
+		// bring a non zero pivot to the diagonal, swapping rows flips the sign
+		if m[k][k].Sign() == 0 {
+			pivot := k + 1
+			for pivot < n && m[pivot][k].Sign() == 0 {
+				pivot++
+			}
+			if pivot == n {
+				return new(big.Int), nil // the column is null, so is the determinant
+			}
+			m[k], m[pivot] = m[pivot], m[k]
+			negative = !negative
+		}
+
+		for i := k + 1; i < n; i++ {
+			// a single step is quadratic, check the deadline for every row
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			for j := k + 1; j < n; j++ {
+				// m[i][j] = (m[i][j] * m[k][k] - m[i][k] * m[k][j]) / prev
+				t1.Mul(m[i][j], m[k][k])
+				t2.Mul(m[i][k], m[k][j])
+				m[i][j].Quo(t1.Sub(t1, t2), prev)
+			}
+		}
+		prev = m[k][k]
+	}
+
+	det := new(big.Int).Set(m[n-1][n-1])
+	if negative {
+		det.Neg(det)
+	}
+	return det, nil
+}
+
+// pivotRow returns the row, from row from on, holding the largest value of
+// column col, which keeps the rounding errors small when dividing by it
+func pivotRow[T any](m [][]T, col, from int, ar arithmetic[T]) int {
+	pivot := from
+	best, cur := ar.abs(ar.zero(), m[from][col]), ar.zero()
+	for i := from + 1; i < len(m); i++ {
+		if cur = ar.abs(cur, m[i][col]); ar.cmp(cur, best) > 0 {
+			pivot, best = i, ar.set(best, cur)
+		}
+	}
+	return pivot
+}
+
+// tolerance returns the magnitude below which a pivot of m counts as zero:
+// 0 for the exact modes, else the error the elimination may accumulate
+func tolerance[T any](m [][]T, ar arithmetic[T]) T {
+	tol, cur := ar.zero(), ar.zero()
+	n := 0
+	for _, row := range m {
+		n = max(n, len(row))
+		for _, x := range row {
+			if cur = ar.abs(cur, x); ar.cmp(cur, tol) > 0 {
+				tol = ar.set(tol, cur)
+			}
+		}
+	}
+	n = max(n, len(m))
+	tol = ar.mul(tol, tol, ar.epsilon(cur))
+	return ar.mul(tol, tol, ar.setInt64(ar.zero(), int64(n)))
+}
+
+// eliminationDeterminant computes the determinant of the square matrix m by
+// Gaussian elimination with partial pivoting, dividing in the numbers of ar.
+// m is overwritten.
+func eliminationDeterminant[T any](ctx context.Context, m [][]T, ar arithmetic[T]) (T, error) {
+	det := ar.setInt64(ar.zero(), 1)
+	factor, tmp := ar.zero(), ar.zero()
+	for k := range m {
+		pivot := pivotRow(m, k, k, ar)
+		if ar.sign(m[pivot][k]) == 0 {
+			return ar.zero(), nil // the column is null, so is the determinant
+		}
+		if pivot != k {
+			// swapping rows flips the sign
+			m[k], m[pivot] = m[pivot], m[k]
+			det = ar.sub(det, ar.zero(), det)
+		}
+		det = ar.mul(det, det, m[k][k])
+
+		for i := k + 1; i < len(m); i++ {
+			// a single step is quadratic, check the deadline for every row
+			if err := ctx.Err(); err != nil {
+				return det, err
+			}
+			if ar.sign(m[i][k]) == 0 {
+				continue
+			}
+			factor = ar.quo(factor, m[i][k], m[k][k])
+			for j := k + 1; j < len(m); j++ {
+				tmp = ar.mul(tmp, factor, m[k][j])
+				m[i][j] = ar.sub(m[i][j], m[i][j], tmp)
+			}
+		}
+	}
+	return det, nil
+}
+
+// Inverse writes the inverse A⁻¹ of the square matrix read from src to dst.
+// It is exact unless the Decimal or Float64 numeric mode is set, and the
+// cells of the inverse of an integer matrix are integers or fractions such as
+// "3/7". ErrSingular is reported when the matrix has no inverse.
+func Inverse(ctx context.Context, src io.Reader, dst io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	reader := newReader(ctx, src, cfg)
+	reader.RequireSquare = true
+	reader.holdRows(0, cfg.memoryBudget)
+
+	w := cfg.resultWriter(dst)
+	if err := newEngine(cfg).inverse(ctx, reader, w); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// writeInverse inverts m and writes the rows of the inverse to w
+func writeInverse[T any](ctx context.Context, m [][]T, ar arithmetic[T], w RowWriter) error {
+	inv, err := gaussJordan(ctx, m, ar)
+	if err != nil {
+		return err
+	}
+	row := make([]string, len(inv))
+	for _, cells := range inv {
+		for j, cell := range cells {
+			row[j] = ar.format(cell)
+		}
+		if err = w.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gaussJordan inverts the square matrix m by reducing [m | I] to [I | m⁻¹].
+// A pivot within the tolerance of zero makes the matrix singular. m is
+// overwritten.
+func gaussJordan[T any](ctx context.Context, m [][]T, ar arithmetic[T]) ([][]T, error) {
+	n := len(m)
+	inv := make([][]T, n)
+	for i := range m {
+		inv[i] = make([]T, n)
+		for j := range inv[i] {
+			inv[i][j] = ar.zero()
+		}
+		inv[i][i] = ar.setInt64(inv[i][i], 1)
+	}
+
+	tol := tolerance(m, ar)
+	one := ar.setInt64(ar.zero(), 1)
+	factor, tmp := ar.zero(), ar.zero()
+	for k := 0; k < n; k++ {
+		// bring the largest pivot to the diagonal
+		pivot := pivotRow(m, k, k, ar)
+		if tmp = ar.abs(tmp, m[pivot][k]); ar.sign(tmp) == 0 || ar.cmp(tmp, tol) <= 0 {
+			return nil, newInputError(ErrSingular, "matrix is singular: column %d has no pivot", k+1)
+		}
+		m[k], m[pivot] = m[pivot], m[k]
+		inv[k], inv[pivot] = inv[pivot], inv[k]
+
+		// scale the pivot row so the pivot becomes 1
+		factor = ar.quo(factor, one, m[k][k])
+		for j := 0; j < n; j++ {
+			m[k][j] = ar.mul(m[k][j], m[k][j], factor)
+			inv[k][j] = ar.mul(inv[k][j], inv[k][j], factor)
+		}
+
+		// clear the pivot column in every other row
+		for i := 0; i < n; i++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if i == k || ar.sign(m[i][k]) == 0 {
+				continue
+			}
+			factor = ar.set(factor, m[i][k])
+			for j := 0; j < n; j++ {
+				tmp = ar.mul(tmp, factor, m[k][j])
+				m[i][j] = ar.sub(m[i][j], m[i][j], tmp)
+				tmp = ar.mul(tmp, factor, inv[k][j])
+				inv[i][j] = ar.sub(inv[i][j], inv[i][j], tmp)
+			}
+		}
+	}
+	return inv, nil
+}
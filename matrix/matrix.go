@@ -0,0 +1,248 @@
+// Package matrix implements streaming operations on matrices stored as CSV, one
+// row per line and no header row. Cells are integers of any size, or decimals
+// and fractions when another Numeric mode is chosen with WithNumeric.
+//
+// Every operation reads its input through a Reader, so arbitrarily large files
+// are processed row by row, and writes its result to an io.Writer through a
+// RowWriter, as CSV unless another Format is chosen with WithOutputFormat:
+//
+//	err := matrix.Sum(ctx, file, os.Stdout)
+package matrix
+
+import (
+	"context"
+	"io"
+	"math/big"
+	"runtime"
+)
+
+const (
+	readBufferSize  = 64 * 1024  // 64KB, default of WithBufferSizes
+	writeBufferSize = 128 * 1024 // 128KB, default of WithBufferSizes
+
+	defaultMemoryBudget = 64 * 1024 * 1024 // 64MB
+	defaultPrecision    = 128              // bits of the mantissa of decimal numbers
+	defaultMaxFindings  = 100
+)
+
+// Operation is the signature shared by the single-input operations of this
+// package, which makes them easy to plug into routers and job runners.
+type Operation func(ctx context.Context, src io.Reader, dst io.Writer, opts ...Option) error
+
+// Option tunes how an operation reads, computes and spills intermediate data.
+type Option func(*config)
+
+type config struct {
+	tempDir      string
+	format       Format
+	inputFormat  Format
+	memoryBudget int64
+	readBuffer   int
+	writeBuffer  int
+	concurrency  int
+	numeric      Numeric
+	precision    uint
+	rounding     big.RoundingMode
+	places       int
+	modulus      *big.Int
+	maxFindings  int
+	progress     func(rows int)
+	phase        func(phase Phase)
+	spill        func(bytes int)
+	limits       limits
+}
+
+// WithTempDir sets the directory used for temporary files. The default is the
+// system temporary directory.
+func WithTempDir(dir string) Option {
+	return func(c *config) {
+		c.tempDir = dir
+	}
+}
+
+// WithOutputFormat sets the format of the result. The default is CSV.
+func WithOutputFormat(f Format) Option {
+	return func(c *config) {
+		c.format = f
+	}
+}
+
+// WithInputFormat sets the format the inputs are decoded from, unless they are
+// passed as an Input. The default is CSV.
+func WithInputFormat(f Format) Option {
+	return func(c *config) {
+		c.inputFormat = f
+	}
+}
+
+// WithMemoryBudget bounds the memory, in bytes, an operation spilling to
+// temporary files such as Transpose uses for the data it buffers. The default
+// is 64MB.
+func WithMemoryBudget(bytes int64) Option {
+	return func(c *config) {
+		c.memoryBudget = bytes
+	}
+}
+
+// WithConcurrency sets the number of goroutines parsing and computing chunks
+// of rows in Sum, Multiply and Transpose, while a single goroutine reads the
+// input. The default is runtime.GOMAXPROCS(0).
+func WithConcurrency(n int) Option {
+	return func(c *config) {
+		c.concurrency = n
+	}
+}
+
+// WithNumeric sets the kind of numbers the cells are parsed as and computed
+// with. The default is Int, which rejects cells such as "1.5".
+func WithNumeric(n Numeric) Option {
+	return func(c *config) {
+		c.numeric = n
+	}
+}
+
+// WithPrecision sets the number of bits of the mantissa of Decimal numbers.
+// The default is 128, about 38 significant digits.
+func WithPrecision(bits uint) Option {
+	return func(c *config) {
+		c.precision = bits
+	}
+}
+
+// WithRounding sets how Decimal operations round their results, and how
+// results are rounded to the places set with WithDecimalPlaces. The default
+// is big.ToNearestEven.
+func WithRounding(mode big.RoundingMode) Option {
+	return func(c *config) {
+		c.rounding = mode
+	}
+}
+
+// WithDecimalPlaces makes the numbers of the result be written with exactly
+// places decimals. By default, or when places is negative, they are written
+// as computed: integers, fractions such as "2/3" in the Rational mode, and
+// the shortest decimal representation in the Decimal and Float64 modes. That
+// decimal representation is what gets rounded, so 2.675 rounds half up to
+// 2.68 even though its binary value is slightly below.
+func WithDecimalPlaces(places int) Option {
+	return func(c *config) {
+		c.places = places
+	}
+}
+
+// WithBufferSizes sets the size of the buffers reading the inputs and writing
+// the result. The defaults are 64KB and 128KB, a size below 1 keeps its
+// default.
+func WithBufferSizes(read, write int) Option {
+	return func(c *config) {
+		if read > 0 {
+			c.readBuffer = read
+		}
+		if write > 0 {
+			c.writeBuffer = write
+		}
+	}
+}
+
+// WithMaxRows makes the operations fail with ErrTooLarge on the row after the
+// n-th one. The default 0 is no limit, like for the other limits.
+func WithMaxRows(n int) Option {
+	return func(c *config) {
+		c.limits.rows = n
+	}
+}
+
+// WithMaxColumns makes the operations fail with ErrTooLarge on a row of more
+// than n cells.
+func WithMaxColumns(n int) Option {
+	return func(c *config) {
+		c.limits.cols = n
+	}
+}
+
+// WithMaxCellLength makes the operations fail with ErrTooLarge on a cell of
+// more than n bytes, before it is parsed.
+func WithMaxCellLength(n int) Option {
+	return func(c *config) {
+		c.limits.cellLength = n
+	}
+}
+
+// WithMaxResultDigits makes the operations fail with ErrResultTooLarge when a
+// number of the result has more than n digits. Multiply gives up as soon as
+// the product read so far is known to exceed them, in every numeric mode: in
+// the Rational and Decimal modes a product may then fail even though the
+// factors left would have made it smaller.
+func WithMaxResultDigits(n int) Option {
+	return func(c *config) {
+		c.limits.resultDigits = n
+	}
+}
+
+// WithProgress sets a function called as the rows of the inputs are read,
+// every 1000 rows and once an input is exhausted, with the number of rows
+// read since the previous call. It may be called from another goroutine than
+// the operation's, but never concurrently for the same input.
+func WithProgress(fn func(rows int)) Option {
+	return func(c *config) {
+		c.progress = fn
+	}
+}
+
+// Phase names a stage of an operation that runs in several passes.
+type Phase string
+
+const (
+	PhaseTiling      Phase = "tiling"      // tiles of rows are spilled into temporary files
+	PhaseMerging     Phase = "merging"     // the tiles are merged back into the rows of the result
+	PhaseMultiplying Phase = "multiplying" // the rows of A are multiplied by the transposed B
+)
+
+// WithPhase sets a function called when an operation enters a new phase, such
+// as the tiling and merging of a Transpose. Single pass operations never call
+// it.
+func WithPhase(fn func(phase Phase)) Option {
+	return func(c *config) {
+		c.phase = fn
+	}
+}
+
+// WithSpill sets a function called with the number of bytes written to the
+// temporary files of an operation, as the tiles of a transpose are spilled.
+// Like the progress function it may be called from another goroutine.
+func WithSpill(fn func(bytes int)) Option {
+	return func(c *config) {
+		c.spill = fn
+	}
+}
+
+// enter reports that the operation enters phase
+func (c *config) enter(phase Phase) {
+	if c.phase != nil {
+		c.phase(phase)
+	}
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		format:       CSV,
+		inputFormat:  CSV,
+		memoryBudget: defaultMemoryBudget,
+		readBuffer:   readBufferSize,
+		writeBuffer:  writeBufferSize,
+		numeric:      Int,
+		precision:    defaultPrecision,
+		places:       -1,
+		maxFindings:  defaultMaxFindings,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.concurrency < 1 {
+		c.concurrency = runtime.GOMAXPROCS(0)
+	}
+	if c.precision == 0 {
+		c.precision = defaultPrecision
+	}
+	return c
+}
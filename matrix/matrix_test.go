@@ -0,0 +1,1176 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperations(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      Operation
+		input   string
+		want    string
+		wantErr error
+	}{
+		{name: "Echo", op: Echo, input: "1,2\n3,4", want: "1,2\n3,4\n"},
+		{name: "Echo not square", op: Echo, input: "1,2\n3,4\n5,6", wantErr: ErrNotSquare},
+		{name: "Echo ragged row", op: Echo, input: "1,2\n3", wantErr: ErrRaggedRow},
+		{name: "Flatten", op: Flatten, input: "1,2\n3,4", want: "1,2,3,4\n"},
+		{name: "Transpose", op: Transpose, input: "1,2,3\n4,5,6\n7,8,9", want: "1,4,7\n2,5,8\n3,6,9\n"},
+		{name: "Transpose non square", op: Transpose, input: "1,2,3\n4,5,6", want: "1,4\n2,5\n3,6\n"},
+		{name: "Sum", op: Sum, input: "1,2\n3,4", want: "10\n"},
+		{name: "Sum not a number", op: Sum, input: "1,a\n3,4", wantErr: ErrNotNumber},
+		{name: "Multiply", op: Multiply, input: "2,3\n4,5", want: "120\n"},
+		{name: "Multiply with zero", op: Multiply, input: "2,0\n3,4", want: "0\n"},
+		{name: "Determinant", op: Determinant, input: "2,-3,1\n2,0,-1\n1,4,5", want: "49\n"},
+		{name: "Determinant needs a row swap", op: Determinant, input: "0,1\n1,0", want: "-1\n"},
+		{name: "Determinant singular", op: Determinant, input: "1,2,3\n4,5,6\n7,8,9", want: "0\n"},
+		{name: "Determinant big numbers", op: Determinant, input: "123456789012345678901234567890,1\n1,2", want: "246913578024691357802469135779\n"},
+		{name: "Determinant not square", op: Determinant, input: "1,2,3\n4,5,6", wantErr: ErrNotSquare},
+		{name: "Inverse", op: Inverse, input: "1,2\n3,4", want: "-2,1\n3/2,-1/2\n"},
+		{name: "Inverse needs a row swap", op: Inverse, input: "0,1,0\n2,0,0\n0,0,7", want: "0,1/2,0\n1,0,0\n0,0,1/7\n"},
+		{name: "Inverse singular", op: Inverse, input: "1,2\n2,4", wantErr: ErrSingular},
+		{name: "Inverse not square", op: Inverse, input: "1,2", wantErr: ErrNotSquare},
+		{name: "Syntax error", op: Sum, input: "1,\"2\n3,4", wantErr: ErrSyntax},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := tt.op(context.Background(), strings.NewReader(tt.input), &out, WithTempDir(t.TempDir()))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.True(t, IsInputError(err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
+
+func TestTransposeLargerThanBlocks(t *testing.T) {
+	// 20 rows spilled as tiles of a single row
+	var input, want strings.Builder
+	const n = 20
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if j > 0 {
+				input.WriteByte(',')
+				want.WriteByte(',')
+			}
+			fmt.Fprint(&input, i*n+j)
+			fmt.Fprint(&want, j*n+i)
+		}
+		input.WriteByte('\n')
+		want.WriteByte('\n')
+	}
+
+	var out bytes.Buffer
+	err := Transpose(context.Background(), strings.NewReader(input.String()), &out, WithTempDir(t.TempDir()), WithMemoryBudget(1))
+	assert.NoError(t, err)
+	assert.Equal(t, want.String(), out.String())
+}
+
+func TestMatMul(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		want    string
+		wantErr error
+	}{
+		{name: "Square", a: "1,2\n3,4", b: "5,6\n7,8", want: "19,22\n43,50\n"},
+		{name: "Rectangular", a: "1,2,3\n4,5,6", b: "7,8\n9,10\n11,12", want: "58,64\n139,154\n"},
+		{name: "Big numbers", a: "123456789012345678901234567890", b: "2", want: "246913578024691357802469135780\n"},
+		{name: "Inner dimension mismatch", a: "1,2\n3,4", b: "1,2\n3,4\n5,6", wantErr: ErrDimensionMismatch},
+		{name: "Not a number", a: "1,x", b: "1\n2", wantErr: ErrNotNumber},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := MatMul(context.Background(), strings.NewReader(tt.a), strings.NewReader(tt.b), &out, WithTempDir(t.TempDir()))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+
+	t.Run("More rows than a block", func(t *testing.T) {
+		// identity times a tall matrix gives back the tall matrix
+		const n = productBlockRows + 6
+		var a, b strings.Builder
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				if j > 0 {
+					a.WriteByte(',')
+				}
+				if i == j {
+					a.WriteByte('1')
+				} else {
+					a.WriteByte('0')
+				}
+			}
+			a.WriteByte('\n')
+			fmt.Fprintf(&b, "%d,%d\n", i, -i)
+		}
+
+		var out bytes.Buffer
+		err := MatMul(context.Background(), strings.NewReader(a.String()), strings.NewReader(b.String()), &out, WithTempDir(t.TempDir()))
+		assert.NoError(t, err)
+		assert.Equal(t, b.String(), out.String())
+	})
+}
+
+func TestElementwise(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      BinaryOperation
+		a, b    string
+		want    string
+		wantErr string
+	}{
+		{name: "Add", op: Add, a: "1,2\n3,4", b: "10,20\n30,40", want: "11,22\n33,44\n"},
+		{name: "Subtract", op: Subtract, a: "1,2\n3,4", b: "10,20\n30,40", want: "-9,-18\n-27,-36\n"},
+		{name: "Hadamard", op: Hadamard, a: "1,2\n3,4", b: "10,20\n30,40", want: "10,40\n90,160\n"},
+		{name: "Column mismatch", op: Add, a: "1,2\n3,4", b: "1,2,3\n4,5,6", wantErr: "column number inconsistent: row: 1 expects 2 colums"},
+		{name: "A has more rows", op: Add, a: "1,2\n3,4", b: "1,2", wantErr: "row number inconsistent: line: 2 of a expects 1 rows"},
+		{name: "B has more rows", op: Add, a: "1,2", b: "1,2\n3,4", wantErr: "row number inconsistent: line: 2 of b expects 1 rows"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := tt.op(context.Background(), strings.NewReader(tt.a), strings.NewReader(tt.b), &out)
+			if tt.wantErr != "" {
+				assert.ErrorIs(t, err, ErrDimensionMismatch)
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
+
+func TestDeterminantDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := [][]*big.Int{{big.NewInt(1), big.NewInt(2)}, {big.NewInt(3), big.NewInt(4)}}
+	cancel()
+
+	_, err := bareiss(ctx, m)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestComputeStats(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Stats
+	}{
+		{
+			name:  "Singular",
+			input: "1,2,3\n4,5,6\n7,8,9",
+			want:  Stats{Rows: 3, Cols: 3, Trace: "15", Rank: 2, Nullity: 1},
+		},
+		{
+			name:  "Symmetric",
+			input: "2,1,0\n1,2,1\n0,1,2",
+			want:  Stats{Rows: 3, Cols: 3, Trace: "6", Rank: 3, Symmetric: true},
+		},
+		{
+			name:  "Rectangular with null column",
+			input: "0,1,2,3\n0,2,4,6",
+			want:  Stats{Rows: 2, Cols: 4, Rank: 1, Nullity: 3},
+		},
+		{
+			name:  "Zero matrix",
+			input: "0,0\n0,0",
+			want:  Stats{Rows: 2, Cols: 2, Trace: "0", Rank: 0, Nullity: 2, Symmetric: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := ComputeStats(context.Background(), strings.NewReader(tt.input))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, *stats)
+		})
+	}
+}
+
+func TestRowWriter(t *testing.T) {
+	rows := [][]string{{"1", "-2"}, {"3/7", "a,b"}}
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{format: CSV, want: "1,-2\n3/7,\"a,b\"\n"},
+		{format: TSV, want: "1\t-2\n3/7\ta,b\n"},
+		{format: JSON, want: `[[1,-2],["3/7","a,b"]]` + "\n"},
+		{format: NDJSON, want: "[1,-2]\n[\"3/7\",\"a,b\"]\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var out bytes.Buffer
+			w := NewRowWriter(&out, tt.format)
+			for _, row := range rows {
+				assert.NoError(t, w.WriteRow(row))
+			}
+			assert.NoError(t, w.Close())
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+
+	t.Run("Empty json document", func(t *testing.T) {
+		var out bytes.Buffer
+		assert.NoError(t, NewRowWriter(&out, JSON).Close())
+		assert.Equal(t, "[]\n", out.String())
+	})
+
+	t.Run("Row streamed cell by cell", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Flatten(context.Background(), strings.NewReader("1,2\n3,4"), &out, WithOutputFormat(JSON))
+		assert.NoError(t, err)
+		assert.Equal(t, "[[1,2,3,4]]\n", out.String())
+	})
+
+	t.Run("Only complete rows pushed", func(t *testing.T) {
+		for _, format := range []Format{CSV, NDJSON} {
+			var out bytes.Buffer
+			w := newRowWriter(&out, format, 16)
+			for i := 0; i < 20; i++ {
+				assert.NoError(t, w.WriteRow([]string{"123", "456"}))
+				if out.Len() > 0 {
+					assert.Equal(t, byte('\n'), out.Bytes()[out.Len()-1], format)
+				}
+			}
+			assert.NoError(t, w.WriteCells([]string{"7"}))
+			assert.NotZero(t, out.Len(), format)
+			assert.Equal(t, byte('\n'), out.Bytes()[out.Len()-1], format)
+
+			// a row larger than the buffer cannot be held whole
+			assert.NoError(t, w.WriteCells(strings.Split(strings.Repeat("8", 40), "")))
+			assert.NotEqual(t, byte('\n'), out.Bytes()[out.Len()-1], format)
+		}
+	})
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("NDJSON")
+	assert.NoError(t, err)
+	assert.Equal(t, NDJSON, format)
+
+	_, err = ParseFormat("xml")
+	assert.EqualError(t, err, `unsupported format "xml", expected one of csv, tsv, json, ndjson, txt, mtx`)
+}
+
+func TestReader(t *testing.T) {
+	reader := NewReader(strings.NewReader("1,2,3\n4,5,6\n"))
+	reader.RequireSquare = true
+
+	for i := 0; i < 2; i++ {
+		_, err := reader.Read()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, reader.Rows())
+	assert.Equal(t, 3, reader.Cols())
+
+	_, err := reader.Read()
+	assert.ErrorIs(t, err, ErrNotSquare)
+	assert.Contains(t, err.Error(), "Not a matrix: line: 2, columns: 3")
+}
+
+func TestInputFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		input   string
+		want    [][]string
+		wantErr error
+	}{
+		{name: "CSV", format: CSV, input: "1,2\n3,4\n", want: [][]string{{"1", "2"}, {"3", "4"}}},
+		{name: "TSV", format: TSV, input: "1\t2\n3\t4\n", want: [][]string{{"1", "2"}, {"3", "4"}}},
+		{name: "JSON", format: JSON, input: `[[1,"2"],[3,40000000000000000000000]]`, want: [][]string{{"1", "2"}, {"3", "40000000000000000000000"}}},
+		{name: "Empty JSON", format: JSON, input: `[]`, want: nil},
+		{name: "NDJSON", format: NDJSON, input: "[1,2]\n\n[3,4]\n", want: [][]string{{"1", "2"}, {"3", "4"}}},
+		{name: "Text", format: Text, input: "# header\n1  2\n\n\t3 4", want: [][]string{{"1", "2"}, {"3", "4"}}},
+		{name: "JSON not an array", format: JSON, input: `{"a":1}`, wantErr: ErrSyntax},
+		{name: "JSON nested cell", format: JSON, input: `[[1,[2]]]`, wantErr: ErrSyntax},
+		{name: "JSON truncated", format: JSON, input: `[[1,2],[3`, wantErr: ErrSyntax},
+		{name: "Text ragged", format: Text, input: "1 2\n3", wantErr: ErrRaggedRow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := NewReader(strings.NewReader(tt.input), WithInputFormat(tt.format))
+			var rows [][]string
+			for {
+				record, err := reader.Read()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					assert.ErrorIs(t, err, tt.wantErr)
+					return
+				}
+				rows = append(rows, append([]string(nil), record...))
+			}
+			assert.Nil(t, tt.wantErr)
+			assert.Equal(t, tt.want, rows)
+		})
+	}
+
+	t.Run("Input overrides the option", func(t *testing.T) {
+		var out bytes.Buffer
+		a := Input{Reader: strings.NewReader("[[1,2]]"), Format: JSON}
+		b := Input{Reader: strings.NewReader("3 4"), Format: Text}
+		assert.NoError(t, Add(context.Background(), a, b, &out, WithInputFormat(TSV)))
+		assert.Equal(t, "4,6\n", out.String())
+	})
+}
+
+func TestMatrixMarket(t *testing.T) {
+	const (
+		general = "%%MatrixMarket matrix coordinate integer general\n% comment\n2 2 3\n1 1 1\n2 1 3\n2 2 4\n"
+		symm    = "%%MatrixMarket matrix coordinate integer symmetric\n2 2 2\n1 1 5\n2 1 7\n"
+		pattern = "%%MatrixMarket matrix coordinate pattern general\n2 2 4\n1 1\n1 2\n2 1\n2 2\n"
+		array   = "%%MatrixMarket matrix array integer general\n2 3\n1\n4\n2\n5\n3\n6\n"
+	)
+	tests := []struct {
+		name    string
+		op      Operation
+		input   string
+		format  Format
+		want    string
+		wantErr error
+	}{
+		{name: "Echo dense", op: Echo, input: general, format: CSV, want: "1,0\n3,4\n"},
+		{name: "Echo sparse", op: Echo, input: general, format: MatrixMarket, want: "%%MatrixMarket matrix coordinate integer general\n2 2 3\n1 1 1\n2 1 3\n2 2 4\n"},
+		{name: "Echo not square", op: Echo, input: array, format: CSV, wantErr: ErrNotSquare},
+		{name: "Transpose dense", op: Transpose, input: general, format: CSV, want: "1,3\n0,4\n"},
+		{name: "Transpose sparse", op: Transpose, input: general, format: MatrixMarket, want: "%%MatrixMarket matrix coordinate integer general\n2 2 3\n1 1 1\n1 2 3\n2 2 4\n"},
+		{name: "Transpose array", op: Transpose, input: array, format: CSV, want: "1,4\n2,5\n3,6\n"},
+		{name: "Symmetric dense", op: Echo, input: symm, format: CSV, want: "5,7\n7,0\n"},
+		{name: "Sum", op: Sum, input: general, format: CSV, want: "8\n"},
+		{name: "Sum symmetric", op: Sum, input: symm, format: CSV, want: "19\n"},
+		{name: "Multiply missing entries", op: Multiply, input: general, format: CSV, want: "0\n"},
+		{name: "Multiply pattern", op: Multiply, input: pattern, format: CSV, want: "1\n"},
+		{name: "Multiply array", op: Multiply, input: array, format: CSV, want: "720\n"},
+		{name: "Dense operation", op: Determinant, input: general, format: CSV, want: "4\n"},
+		{name: "Entry out of range", op: Sum, input: "%%MatrixMarket matrix coordinate integer general\n2 2 1\n3 1 1\n", wantErr: ErrSyntax},
+		{name: "Missing entries", op: Sum, input: "%%MatrixMarket matrix coordinate integer general\n2 2 2\n1 1 1\n", wantErr: ErrSyntax},
+		{name: "Bad banner", op: Echo, input: "1 2\n3 4\n", wantErr: ErrSyntax},
+		{name: "Huge size", op: Determinant, input: "%%MatrixMarket matrix coordinate integer general\n1 100000000000000 0\n", wantErr: ErrSyntax},
+		{name: "Huge size transposed", op: Transpose, input: "%%MatrixMarket matrix coordinate integer general\n1 100000000000000 0\n", format: CSV, wantErr: ErrSyntax},
+		{name: "Too wide to densify", op: Determinant, input: "%%MatrixMarket matrix coordinate integer general\n1 2000000000 0\n", wantErr: ErrTooLarge},
+		{name: "Too wide to transpose", op: Transpose, input: "%%MatrixMarket matrix coordinate integer general\n2000000000 1 0\n", format: CSV, wantErr: ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			src := Input{Reader: strings.NewReader(tt.input), Format: MatrixMarket}
+			err := tt.op(context.Background(), src, &out, WithOutputFormat(tt.format))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+
+	t.Run("Dense result written as MatrixMarket", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Inverse(context.Background(), strings.NewReader("2,0\n0,4"), &out, WithOutputFormat(MatrixMarket))
+		assert.EqualError(t, err, `cell "1/2" cannot be written in MatrixMarket format`)
+		assert.ErrorIs(t, err, ErrNotNumber)
+
+		out.Reset()
+		err = Echo(context.Background(), strings.NewReader("1,0\n0,2.5"), &out, WithOutputFormat(MatrixMarket))
+		assert.NoError(t, err)
+		assert.Equal(t, "%%MatrixMarket matrix coordinate real general\n2 2 2\n1 1 1\n2 2 2.5\n", out.String())
+
+		for _, cell := range []string{"NaN", "+Inf", "-infinity"} {
+			err = newRowWriter(io.Discard, MatrixMarket, 16).WriteRow([]string{"1", cell})
+			assert.ErrorIs(t, err, ErrNotNumber, cell)
+		}
+	})
+
+	t.Run("Entries spilled beyond the memory budget", func(t *testing.T) {
+		var input strings.Builder
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(&input, "%d,0,%d\n", i+1, i)
+		}
+		var want, out bytes.Buffer
+		err := Transpose(context.Background(), strings.NewReader(input.String()), &want, WithOutputFormat(MatrixMarket))
+		require.NoError(t, err)
+
+		dir := t.TempDir()
+		err = Transpose(context.Background(), strings.NewReader(input.String()), &out, WithOutputFormat(MatrixMarket), WithMemoryBudget(64), WithTempDir(dir))
+		require.NoError(t, err)
+		assert.Equal(t, want.String(), out.String())
+		assert.True(t, strings.HasPrefix(out.String(), "%%MatrixMarket matrix coordinate integer general\n3 100 199\n"))
+		entries, _ := os.ReadDir(dir)
+		assert.Empty(t, entries)
+	})
+}
+
+func TestConcurrency(t *testing.T) {
+	// 1000 rows span several chunks of rows
+	var ones, ramp strings.Builder
+	for i := 0; i < 1000; i++ {
+		ones.WriteString("1,-1,1\n")
+		fmt.Fprintf(&ramp, "%d,%d\n", i, i+1)
+	}
+	withBadRows := func(rows ...string) string {
+		lines := strings.Split(strings.TrimSpace(ones.String()), "\n")
+		for i, row := range rows {
+			if row != "" {
+				lines[i] = row
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+	zeroThenInvalid := make([]string, 900)
+	zeroThenInvalid[300], zeroThenInvalid[800] = "1,0,1", "1,x,1"
+
+	tests := []struct {
+		name    string
+		op      Operation
+		input   string
+		want    string
+		wantErr string
+	}{
+		{name: "Sum", op: Sum, input: ramp.String(), want: "1000000\n"},
+		{name: "Multiply", op: Multiply, input: ones.String(), want: "1\n"},
+		{name: "Multiply zero skips the rows left", op: Multiply, input: withBadRows(zeroThenInvalid...), want: "0\n"},
+		{name: "First error wins", op: Sum, input: withBadRows(append(make([]string, 300), "1,y,1", "1,2")...), wantErr: "y is not a number"},
+		{name: "Ragged row before invalid number", op: Sum, input: withBadRows(append(make([]string, 600), "1,2", "1,z,1")...), wantErr: "row: 601 expects 3 colums"},
+	}
+
+	for _, tt := range tests {
+		for _, workers := range []int{1, 2, 8} {
+			t.Run(fmt.Sprintf("%s with %d workers", tt.name, workers), func(t *testing.T) {
+				var out bytes.Buffer
+				err := tt.op(context.Background(), strings.NewReader(tt.input), &out, WithConcurrency(workers))
+				if tt.wantErr != "" {
+					assert.ErrorContains(t, err, tt.wantErr)
+					return
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, out.String())
+			})
+		}
+	}
+
+	t.Run("Transpose", func(t *testing.T) {
+		input, want := testMatrix(300, 40)
+		for _, workers := range []int{1, 2, 8} {
+			var out bytes.Buffer
+			err := Transpose(context.Background(), strings.NewReader(input), &out, WithTempDir(t.TempDir()), WithConcurrency(workers), WithMemoryBudget(64*1024))
+			assert.NoError(t, err)
+			assert.Equal(t, want, out.String())
+		}
+	})
+}
+
+func TestIntAccumulators(t *testing.T) {
+	max, min := "9223372036854775807", "-9223372036854775808"
+	tests := []struct {
+		name  string
+		cells []string
+	}{
+		{name: "Empty"},
+		{name: "Small", cells: []string{"1", "-2", "3", " 4 ", "+5"}},
+		{name: "Around MaxInt64", cells: []string{max, "1", max, max, "-1"}},
+		{name: "Around MinInt64", cells: []string{min, "-1", min, "2", min}},
+		{name: "Cells beyond int64", cells: []string{"-98765432109876543210987654321", max, "12345678901234567890123"}},
+		{name: "Zero", cells: []string{max, "-7", "0", "123456789012345678901234567890"}},
+		{name: "Word sized magnitudes", cells: []string{"4294967296", "-4294967296", "4294967295", "3", min, "-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantSum, wantProduct, x := new(big.Int), big.NewInt(1), new(big.Int)
+			var sum intSum
+			product := newIntProduct()
+			for _, cell := range tt.cells {
+				assert.NoError(t, parseInt(x, cell))
+				wantSum.Add(wantSum, x)
+				wantProduct.Mul(wantProduct, x)
+				assert.NoError(t, sum.addCell(cell))
+				assert.NoError(t, product.mulCell(cell))
+			}
+			assert.Equal(t, wantSum.String(), sum.Int().String())
+			assert.Equal(t, wantProduct.String(), product.Int().String())
+		})
+	}
+
+	t.Run("Invalid cell", func(t *testing.T) {
+		var sum intSum
+		assert.ErrorIs(t, sum.addCell("1.5"), ErrNotNumber)
+		assert.ErrorIs(t, newIntProduct().mulCell("x"), ErrNotNumber)
+	})
+
+	t.Run("Matches big.Int", func(t *testing.T) {
+		var input strings.Builder
+		assert.NoError(t, WriteRandom(&input, 600, 40, rand.New(rand.NewSource(1))))
+		ar := intArithmetic{numberFormat{places: -1}}
+		for _, workers := range []int{1, 4} {
+			reader := func() *Reader { return NewReader(strings.NewReader(input.String())) }
+			wantSum, err := sumOf(context.Background(), reader(), ar, workers)
+			assert.NoError(t, err)
+			sum, err := sumInts(context.Background(), reader(), workers)
+			assert.NoError(t, err)
+			assert.Equal(t, wantSum.String(), sum.String())
+
+			wantProduct, err := productOf(context.Background(), reader(), ar, workers, limits{})
+			assert.NoError(t, err)
+			product, err := productInts(context.Background(), reader(), workers, limits{})
+			assert.NoError(t, err)
+			assert.Equal(t, wantProduct.String(), product.String())
+		}
+	})
+}
+
+func TestNumericModes(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      Operation
+		input   string
+		opts    []Option
+		want    string
+		wantErr error
+	}{
+		{name: "Int rejects decimals", op: Sum, input: "1.5,2", wantErr: ErrNotNumber},
+		{name: "Int with places", op: Sum, input: "1,2", opts: []Option{WithDecimalPlaces(2)}, want: "3.00\n"},
+		{name: "Rational sum", op: Sum, input: "1.5,2.25\n0.1,0.2", opts: []Option{WithNumeric(Rational)}, want: "81/20\n"},
+		{name: "Rational sum with places", op: Sum, input: "1.5,2.25\n0.1,0.2", opts: []Option{WithNumeric(Rational), WithDecimalPlaces(2)}, want: "4.05\n"},
+		{name: "Rational product", op: Multiply, input: "2/3,3/4", opts: []Option{WithNumeric(Rational)}, want: "1/2\n"},
+		{name: "Rational rejects text", op: Sum, input: "1/2,abc", opts: []Option{WithNumeric(Rational)}, wantErr: ErrNotNumber},
+		{name: "Decimal sum", op: Sum, input: "1000,0.75", opts: []Option{WithNumeric(Decimal)}, want: "1000.75\n"},
+		{name: "Decimal fraction", op: Sum, input: "1/3,2/3", opts: []Option{WithNumeric(Decimal), WithDecimalPlaces(3)}, want: "1.000\n"},
+		{name: "Decimal precision", op: Sum, input: "1000,0.75", opts: []Option{WithNumeric(Decimal), WithPrecision(8)}, want: "1000\n"},
+		{name: "Decimal rejects infinity", op: Sum, input: "1,Inf", opts: []Option{WithNumeric(Decimal)}, wantErr: ErrNotNumber},
+		{name: "Float64 sum", op: Sum, input: "0.1,0.2", opts: []Option{WithNumeric(Float64)}, want: "0.30000000000000004\n"},
+		{name: "Float64 sum with places", op: Sum, input: "0.1,0.2", opts: []Option{WithNumeric(Float64), WithDecimalPlaces(2)}, want: "0.30\n"},
+		{name: "Float64 rounds the decimal written", op: Sum, input: "2.675", opts: []Option{WithNumeric(Float64), WithDecimalPlaces(2), WithRounding(big.ToNearestAway)}, want: "2.68\n"},
+		{name: "Decimal rounds the decimal written", op: Sum, input: "1.10,2.205,3,0.1", opts: []Option{WithNumeric(Decimal), WithDecimalPlaces(2)}, want: "6.40\n"},
+		{name: "Float64 rejects NaN", op: Multiply, input: "1,NaN", opts: []Option{WithNumeric(Float64)}, wantErr: ErrNotNumber},
+		{name: "Rational determinant", op: Determinant, input: "1/2,1\n1,4", opts: []Option{WithNumeric(Rational)}, want: "1\n"},
+		{name: "Decimal determinant needs a row swap", op: Determinant, input: "0,1.5\n2,0", opts: []Option{WithNumeric(Decimal)}, want: "-3\n"},
+		{name: "Float64 determinant", op: Determinant, input: "0.5,1\n1,4", opts: []Option{WithNumeric(Float64)}, want: "1\n"},
+		{name: "Rational inverse", op: Inverse, input: "1/2,0\n0,0.25", opts: []Option{WithNumeric(Rational)}, want: "2,0\n0,4\n"},
+		{name: "Float64 inverse", op: Inverse, input: "4,7\n2,6", opts: []Option{WithNumeric(Float64), WithDecimalPlaces(2)}, want: "0.60,-0.70\n-0.20,0.40\n"},
+		{name: "Int inverse with places", op: Inverse, input: "1,2\n3,4", opts: []Option{WithDecimalPlaces(1)}, want: "-2.0,1.0\n1.5,-0.5\n"},
+		{name: "Float64 inverse nearly singular", op: Inverse, input: "0.1,0.2\n0.3,0.6", opts: []Option{WithNumeric(Float64)}, wantErr: ErrSingular},
+		{name: "Decimal inverse singular", op: Inverse, input: "1.5,3\n1,2", opts: []Option{WithNumeric(Decimal)}, wantErr: ErrSingular},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := tt.op(context.Background(), strings.NewReader(tt.input), &out, tt.opts...)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+
+	t.Run("Element-wise and product", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Add(context.Background(), strings.NewReader("0.5,1.25"), strings.NewReader("0.25,0.75"), &out, WithNumeric(Decimal))
+		assert.NoError(t, err)
+		assert.Equal(t, "0.75,2\n", out.String())
+
+		out.Reset()
+		err = MatMul(context.Background(), strings.NewReader("1/2,1"), strings.NewReader("2\n1/3"), &out, WithNumeric(Rational), WithTempDir(t.TempDir()))
+		assert.NoError(t, err)
+		assert.Equal(t, "4/3\n", out.String())
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		stats, err := ComputeStats(context.Background(), strings.NewReader("1.5,0\n0,2.5"), WithNumeric(Decimal))
+		assert.NoError(t, err)
+		assert.Equal(t, Stats{Rows: 2, Cols: 2, Trace: "4", Rank: 2, Symmetric: true}, *stats)
+
+		stats, err = ComputeStats(context.Background(), strings.NewReader("0.1,0.2\n0.3,0.6"), WithNumeric(Float64))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, stats.Rank)
+
+		stats, err = ComputeStats(context.Background(), strings.NewReader("1/2,1\n1,3"), WithNumeric(Rational))
+		assert.NoError(t, err)
+		data, err := json.Marshal(stats)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"rows":2,"columns":2,"trace":"7/2","rank":2,"nullity":0,"symmetric":true}`, string(data))
+	})
+}
+
+func TestRounding(t *testing.T) {
+	tests := []struct {
+		value    string
+		rounding string
+		want     string
+	}{
+		{value: "1/8", rounding: "half_even", want: "0.12"},
+		{value: "3/8", rounding: "half_even", want: "0.38"},
+		{value: "1/8", rounding: "half_up", want: "0.13"},
+		{value: "-1/8", rounding: "half_up", want: "-0.13"},
+		{value: "-0.129", rounding: "down", want: "-0.12"},
+		{value: "0.121", rounding: "up", want: "0.13"},
+		{value: "-0.121", rounding: "floor", want: "-0.13"},
+		{value: "-0.129", rounding: "ceiling", want: "-0.12"},
+		{value: "-0.001", rounding: "half_even", want: "0.00"},
+		{value: "12345", rounding: "half_even", want: "12345.00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value+" "+tt.rounding, func(t *testing.T) {
+			mode, err := ParseRounding(tt.rounding)
+			assert.NoError(t, err)
+			var out bytes.Buffer
+			err = Sum(context.Background(), strings.NewReader(tt.value), &out, WithNumeric(Rational), WithRounding(mode), WithDecimalPlaces(2))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want+"\n", out.String())
+		})
+	}
+
+	_, err := ParseRounding("nearest")
+	assert.EqualError(t, err, `unsupported rounding "nearest", expected one of half_even, half_up, down, up, floor, ceiling`)
+	_, err = ParseNumeric("complex")
+	assert.EqualError(t, err, `unsupported numeric mode "complex", expected one of int, decimal, rational, float64`)
+}
+
+func TestModulus(t *testing.T) {
+	mod := func(n int64) Option { return WithModulus(big.NewInt(n)) }
+	tests := []struct {
+		name    string
+		op      Operation
+		input   string
+		opts    []Option
+		want    string
+		wantErr error
+	}{
+		{name: "Sum", op: Sum, input: "5,6\n-7,8", opts: []Option{mod(7)}, want: "5\n"},
+		{name: "Multiply", op: Multiply, input: "123456789012345678901234567890,3\n-1,5", opts: []Option{mod(1000)}, want: "650\n"},
+		{name: "Multiply to zero", op: Multiply, input: "2,3\n2,x", opts: []Option{mod(6)}, want: "0\n"},
+		{name: "Echo leaves the cells as they are", op: Echo, input: "-1,8\n9,2", opts: []Option{mod(7)}, want: "-1,8\n9,2\n"},
+		{name: "Determinant", op: Determinant, input: "4,3\n6,3", opts: []Option{mod(7)}, want: "1\n"},
+		{name: "Determinant composite modulus", op: Determinant, input: "2,3\n3,2", opts: []Option{mod(12)}, want: "7\n"},
+		{name: "Inverse", op: Inverse, input: "2,1\n1,1", opts: []Option{mod(5)}, want: "1,4\n4,2\n"},
+		{name: "Inverse composite modulus", op: Inverse, input: "2,3\n3,2", opts: []Option{mod(12)}, want: "2,3\n3,2\n"},
+		{name: "Singular modulo n", op: Inverse, input: "2,0\n0,1", opts: []Option{mod(4)}, wantErr: ErrSingular},
+		{name: "Sum with places", op: Sum, input: "5,6", opts: []Option{mod(7), WithDecimalPlaces(1)}, want: "4.0\n"},
+		{name: "Other modes ignore it", op: Sum, input: "5,6", opts: []Option{mod(7), WithNumeric(Rational)}, want: "11\n"},
+		{name: "Non positive modulus ignored", op: Sum, input: "5,6", opts: []Option{mod(0)}, want: "11\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := tt.op(context.Background(), strings.NewReader(tt.input), &out, tt.opts...)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+
+	t.Run("Binary operations", func(t *testing.T) {
+		var out bytes.Buffer
+		assert.NoError(t, MatMul(context.Background(), strings.NewReader("1,2\n3,4"), strings.NewReader("5,6\n7,8"), &out, WithTempDir(t.TempDir()), mod(10)))
+		assert.Equal(t, "9,2\n3,0\n", out.String())
+		out.Reset()
+		assert.NoError(t, Subtract(context.Background(), strings.NewReader("1,2"), strings.NewReader("3,2"), &out, mod(10)))
+		assert.Equal(t, "8,0\n", out.String())
+	})
+
+	t.Run("Rank modulo a prime", func(t *testing.T) {
+		stats, err := ComputeStats(context.Background(), strings.NewReader("1,2\n3,1"), mod(5))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, stats.Rank)
+		assert.Equal(t, Number("2"), stats.Trace)
+	})
+
+	t.Run("Matches the exact determinant and inverse", func(t *testing.T) {
+		rng := rand.New(rand.NewSource(1))
+		two64 := new(big.Int).Lsh(big.NewInt(1), 64)
+		for _, n := range []*big.Int{big.NewInt(2), big.NewInt(12), big.NewInt(97), big.NewInt(1<<61 - 1), two64} {
+			for size := 1; size <= 6; size++ {
+				var input strings.Builder
+				assert.NoError(t, WriteRandom(&input, size, size, rng))
+				m, err := readMatrix(context.Background(), NewReader(strings.NewReader(input.String())), intArithmetic{})
+				assert.NoError(t, err)
+				want, err := bareiss(context.Background(), m)
+				assert.NoError(t, err)
+
+				var out bytes.Buffer
+				assert.NoError(t, Determinant(context.Background(), strings.NewReader(input.String()), &out, WithModulus(n)))
+				assert.Equal(t, want.Mod(want, n).String()+"\n", out.String(), "%d × %d modulo %s", size, size, n)
+
+				// A × A⁻¹ is the identity when the determinant is invertible
+				out.Reset()
+				err = Inverse(context.Background(), strings.NewReader(input.String()), &out, WithModulus(n))
+				if new(big.Int).GCD(nil, nil, want, n).Cmp(big.NewInt(1)) != 0 {
+					assert.ErrorIs(t, err, ErrSingular, "%d × %d modulo %s", size, size, n)
+					continue
+				}
+				assert.NoError(t, err)
+				var identity bytes.Buffer
+				assert.NoError(t, MatMul(context.Background(), strings.NewReader(input.String()), strings.NewReader(out.String()), &identity, WithTempDir(t.TempDir()), WithModulus(n)))
+				for i, row := range strings.Split(strings.TrimSpace(identity.String()), "\n") {
+					for j, cell := range strings.Split(row, ",") {
+						want := "0"
+						if i == j {
+							want = new(big.Int).Mod(big.NewInt(1), n).String()
+						}
+						assert.Equal(t, want, cell, "%d × %d modulo %s", size, size, n)
+					}
+				}
+			}
+		}
+	})
+}
+
+func TestErrorLocation(t *testing.T) {
+	var tall strings.Builder
+	for i := 0; i < 700; i++ {
+		if i == 600 {
+			tall.WriteString("1,bad\n")
+			continue
+		}
+		tall.WriteString("1,2\n")
+	}
+	matmul := func(b string) Operation {
+		return func(ctx context.Context, src io.Reader, dst io.Writer, opts ...Option) error {
+			return MatMul(ctx, src, strings.NewReader(b), dst, opts...)
+		}
+	}
+	hadamard := func(b string) Operation {
+		return func(ctx context.Context, src io.Reader, dst io.Writer, opts ...Option) error {
+			return Hadamard(ctx, src, strings.NewReader(b), dst, opts...)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		op    Operation
+		input string
+		opts  []Option
+		want  Error
+	}{
+		{name: "Not a number", op: Sum, input: "1,2\n3,x", want: Error{Code: CodeNotNumber, Row: 2, Col: 2, Token: "x"}},
+		{name: "Not a number in a later chunk", op: Multiply, input: tall.String(), want: Error{Code: CodeNotNumber, Row: 601, Col: 2, Token: "bad"}},
+		{name: "Not a number in the rational mode", op: Sum, input: "1/0", opts: []Option{WithNumeric(Rational)}, want: Error{Code: CodeNotNumber, Row: 1, Col: 1, Token: "1/0"}},
+		{name: "Not a number in a determinant", op: Determinant, input: "1,2\n2.5,4", want: Error{Code: CodeNotNumber, Row: 2, Col: 1, Token: "2.5"}},
+		{name: "Not a number in b", op: hadamard("1,2\n3,y"), input: "1,2\n3,4", want: Error{Code: CodeNotNumber, Row: 2, Col: 2, Token: "y"}},
+		{name: "Not a number in b of a product", op: matmul("1\nz"), input: "1,2", want: Error{Code: CodeNotNumber, Row: 2, Col: 1, Token: "z"}},
+		{name: "Not a number in b of an empty product", op: matmul("1,z"), input: "", want: Error{Code: CodeNotNumber, Row: 1, Col: 2, Token: "z"}},
+		{name: "Ragged b of an empty product", op: matmul("1,2\n3"), input: "", want: Error{Code: CodeRaggedRow, Row: 2, Col: 2}},
+		{name: "Row too long", op: Sum, input: "1,2\n3,4,5", want: Error{Code: CodeRaggedRow, Row: 2, Col: 3, Token: "5"}},
+		{name: "Row too short", op: Sum, input: "1,2\n3", want: Error{Code: CodeRaggedRow, Row: 2, Col: 2}},
+		{name: "Not square", op: Echo, input: "1,2", want: Error{Code: CodeNotSquare}},
+		{name: "Syntax", op: Sum, input: "1,2\n3,\"4", want: Error{Code: CodeSyntax, Row: 2}},
+		{name: "Singular", op: Inverse, input: "1,2\n2,4", want: Error{Code: CodeSingular}},
+		{name: "Dimension mismatch", op: matmul("1,2"), input: "1,2", want: Error{Code: CodeDimensionMismatch}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithTempDir(t.TempDir()), WithConcurrency(4)}, tt.opts...)
+			err := tt.op(context.Background(), strings.NewReader(tt.input), io.Discard, opts...)
+			var e *Error
+			if assert.ErrorAs(t, err, &e) {
+				assert.Equal(t, tt.want.Code, e.Code)
+				assert.Equal(t, tt.want.Row, e.Row)
+				assert.Equal(t, tt.want.Col, e.Col)
+				assert.Equal(t, tt.want.Token, e.Token)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		opts   []Option
+		want   []Finding
+		rows   int
+		cols   int
+		square bool
+	}{
+		{name: "Valid", input: "1,2\n3,4\n", rows: 2, cols: 2, square: true, want: []Finding{}},
+		{
+			name:  "Every problem",
+			input: "\ufeff1,2,\n3,x\n\n\n5,6,7\n8\n9,y",
+			rows:  5, cols: 2,
+			want: []Finding{
+				{Code: CodeBOM, Row: 1, Col: 1, Line: 1},
+				{Code: CodeTrailingDelimiter, Row: 1, Col: 3, Line: 1},
+				{Code: CodeNotNumber, Row: 2, Col: 2, Line: 2, Token: "x"},
+				{Code: CodeBlankLine, Line: 3},
+				{Code: CodeBlankLine, Line: 4},
+				{Code: CodeRaggedRow, Row: 3, Col: 3, Line: 5, Token: "7"},
+				{Code: CodeRaggedRow, Row: 4, Col: 2, Line: 6},
+				{Code: CodeNotNumber, Row: 5, Col: 2, Line: 7, Token: "y"},
+			},
+		},
+		{
+			name:  "Syntax error in the middle",
+			input: "1,2\n3,a\"b\n5,6",
+			rows:  2, cols: 2, square: true,
+			want: []Finding{{Code: CodeSyntax, Row: 2, Line: 2}},
+		},
+		{
+			name:  "Numeric mode",
+			input: "0.5,1/3\n1,x",
+			opts:  []Option{WithNumeric(Rational)},
+			rows:  2, cols: 2, square: true,
+			want: []Finding{{Code: CodeNotNumber, Row: 2, Col: 2, Line: 2, Token: "x"}},
+		},
+		{
+			name:  "Capped",
+			input: "a,b,c\nd,e,f",
+			opts:  []Option{WithMaxFindings(2)},
+			rows:  2, cols: 3,
+			want: []Finding{{Code: CodeNotNumber, Row: 1, Col: 1, Line: 1, Token: "a"}, {Code: CodeNotNumber, Row: 1, Col: 2, Line: 1, Token: "b"}},
+		},
+		{
+			name:  "Other formats",
+			input: "[[1, 2], [3], [\"z\", 4]]",
+			opts:  []Option{WithInputFormat(JSON)},
+			rows:  3, cols: 2,
+			want: []Finding{{Code: CodeRaggedRow, Row: 2, Col: 2}, {Code: CodeNotNumber, Row: 3, Col: 1, Token: "z"}},
+		},
+		{
+			name:  "Other formats stop at a syntax error",
+			input: "[[1, 2], [3, 4]",
+			opts:  []Option{WithInputFormat(JSON)},
+			rows:  2, cols: 2, square: true,
+			want: []Finding{{Code: CodeSyntax, Row: 3}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := Validate(context.Background(), strings.NewReader(tt.input), tt.opts...)
+			assert.NoError(t, err)
+			for i := range report.Findings {
+				assert.NotEmpty(t, report.Findings[i].Message)
+				report.Findings[i].Message = ""
+			}
+			assert.Equal(t, tt.want, report.Findings)
+			assert.Equal(t, len(tt.want) == 0, report.Valid)
+			assert.Equal(t, tt.rows, report.Rows)
+			assert.Equal(t, tt.cols, report.Cols)
+			assert.Equal(t, tt.square, report.Square)
+		})
+	}
+
+	t.Run("Count beyond the cap", func(t *testing.T) {
+		report, err := Validate(context.Background(), strings.NewReader("a,b,c\nd,e,f"), WithMaxFindings(2))
+		assert.NoError(t, err)
+		assert.Equal(t, 6, report.Count)
+		assert.True(t, report.Truncated)
+	})
+}
+
+func TestProgress(t *testing.T) {
+	input, _ := testMatrix(2500, 3)
+	var calls []int
+	progress := WithProgress(func(rows int) { calls = append(calls, rows) })
+
+	assert.NoError(t, Sum(context.Background(), strings.NewReader(input), io.Discard, progress))
+	assert.Equal(t, []int{1000, 1000, 500}, calls)
+
+	calls = nil
+	_, err := Validate(context.Background(), strings.NewReader(input), progress)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1000, 1000, 500}, calls)
+
+	// both inputs are counted
+	calls = nil
+	assert.NoError(t, MatMul(context.Background(), strings.NewReader("1,2\n3,4"), strings.NewReader("5\n6"), io.Discard, progress))
+	total := 0
+	for _, rows := range calls {
+		total += rows
+	}
+	assert.Equal(t, 4, total)
+
+	var phases []Phase
+	phase := WithPhase(func(p Phase) { phases = append(phases, p) })
+	assert.NoError(t, Transpose(context.Background(), strings.NewReader("1,2\n3,4"), io.Discard, phase))
+	assert.Equal(t, []Phase{PhaseTiling, PhaseMerging}, phases)
+
+	phases = nil
+	assert.NoError(t, MatMul(context.Background(), strings.NewReader("1,2\n3,4"), strings.NewReader("5\n6"), io.Discard, phase))
+	assert.Equal(t, []Phase{PhaseTiling, PhaseMerging, PhaseMultiplying}, phases)
+
+	phases = nil
+	assert.NoError(t, Sum(context.Background(), strings.NewReader("1,2\n3,4"), io.Discard, phase))
+	assert.Empty(t, phases)
+}
+
+func TestSpill(t *testing.T) {
+	var spilled int
+	spill := WithSpill(func(bytes int) { spilled += bytes })
+
+	// every cell is spilled after its length of one byte, as many bytes as
+	// the cell and its delimiter in the input
+	input, _ := testMatrix(30, 20)
+	assert.NoError(t, Transpose(context.Background(), strings.NewReader(input), io.Discard, spill, WithMemoryBudget(64*1024), WithConcurrency(1)))
+	assert.Equal(t, len(input), spilled)
+
+	// and once more by every pass merging tiles
+	spilled = 0
+	assert.NoError(t, Transpose(context.Background(), strings.NewReader(input), io.Discard, spill, WithMemoryBudget(1024)))
+	assert.Greater(t, spilled, len(input))
+	assert.Zero(t, spilled%len(input))
+
+	spilled = 0
+	assert.NoError(t, Sum(context.Background(), strings.NewReader(input), io.Discard, spill))
+	assert.Zero(t, spilled)
+}
+
+func TestBufferSizes(t *testing.T) {
+	input, transposed := testMatrix(40, 40)
+	var out bytes.Buffer
+	assert.NoError(t, Echo(context.Background(), strings.NewReader(input), &out, WithBufferSizes(16, 16)))
+	assert.Equal(t, input, out.String())
+
+	out.Reset()
+	assert.NoError(t, Transpose(context.Background(), strings.NewReader(input), &out, WithBufferSizes(16, 16)))
+	assert.Equal(t, transposed, out.String())
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		op    Operation
+		opts  []Option
+		kind  error
+		want  Error
+	}{
+		{"Rows", "1,2\n3,4\n5,6", Sum, []Option{WithMaxRows(2)}, ErrTooLarge, Error{Code: CodeTooManyRows, Row: 3}},
+		{"Columns", "1,2,3", Echo, []Option{WithMaxColumns(2)}, ErrTooLarge, Error{Code: CodeTooManyColumns, Row: 1, Col: 3}},
+		{"Cell length", "1,1234567", Multiply, []Option{WithMaxCellLength(5)}, ErrTooLarge, Error{Code: CodeCellTooLong, Row: 1, Col: 2, Token: "1234567"}},
+		{"Sparse shape", "%%MatrixMarket matrix coordinate integer general\n3 3 1\n1 1 5\n", Sum, []Option{WithMaxRows(2), WithInputFormat(MatrixMarket)}, ErrTooLarge, Error{Code: CodeTooManyRows}},
+		{"Sparse shape of a dense operation", "%%MatrixMarket matrix coordinate integer general\n1 100000000 0\n", Determinant, []Option{WithMaxColumns(1000), WithInputFormat(MatrixMarket)}, ErrTooLarge, Error{Code: CodeTooManyColumns}},
+		{"Sparse shape of a transpose", "%%MatrixMarket matrix coordinate integer general\n100000000 1 0\n", Transpose, []Option{WithMaxRows(1000), WithInputFormat(MatrixMarket)}, ErrTooLarge, Error{Code: CodeTooManyRows}},
+		{"Sparse file of a large dense matrix", "%%MatrixMarket matrix coordinate integer general\n1000000 1000000 1\n1 1 5\n", Determinant, []Option{WithInputFormat(MatrixMarket)}, ErrTooLarge, Error{Code: CodeTooManyCells}},
+		{"Sparse file of a dense inverse", "%%MatrixMarket matrix coordinate integer general\n100 100 0\n", Inverse, []Option{WithInputFormat(MatrixMarket), WithMemoryBudget(64 * 1000)}, ErrTooLarge, Error{Code: CodeTooManyCells}},
+		{"Sum digits", "99,1", Sum, []Option{WithMaxResultDigits(2)}, ErrResultTooLarge, Error{Code: CodeResultTooLarge}},
+		{"Product digits", strings.Repeat("1000000007,1000000007\n", 1000), Multiply, []Option{WithMaxResultDigits(100)}, ErrResultTooLarge, Error{Code: CodeResultTooLarge}},
+		{"Rational product digits", "99,99", Multiply, []Option{WithMaxResultDigits(3), WithNumeric(Rational)}, ErrResultTooLarge, Error{Code: CodeResultTooLarge}},
+		{"Determinant digits", "99,1\n1,99", Determinant, []Option{WithMaxResultDigits(3)}, ErrResultTooLarge, Error{Code: CodeResultTooLarge}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.op(context.Background(), strings.NewReader(tt.input), io.Discard, tt.opts...)
+			assert.ErrorIs(t, err, tt.kind)
+			var got *Error
+			if assert.ErrorAs(t, err, &got) {
+				assert.Equal(t, tt.want.Code, got.Code)
+				assert.Equal(t, tt.want.Row, got.Row)
+				assert.Equal(t, tt.want.Col, got.Col)
+				assert.Equal(t, tt.want.Token, got.Token)
+			}
+		})
+	}
+
+	t.Run("Wide sparse matrix kept sparse", func(t *testing.T) {
+		// max_columns bounds the dense rows, not the entries of a sparse file
+		input := "%%MatrixMarket matrix coordinate integer general\n1 100000000 1\n1 5 3\n"
+		opts := []Option{WithMaxColumns(1000), WithInputFormat(MatrixMarket)}
+		var out bytes.Buffer
+		assert.NoError(t, Sum(context.Background(), strings.NewReader(input), &out, opts...))
+		assert.Equal(t, "3\n", out.String())
+
+		out.Reset()
+		err := Transpose(context.Background(), strings.NewReader(input), &out, append(opts, WithOutputFormat(MatrixMarket))...)
+		assert.NoError(t, err)
+		assert.Equal(t, "%%MatrixMarket matrix coordinate integer general\n100000000 1 1\n5 1 3\n", out.String())
+	})
+
+	t.Run("Product given up while streaming", func(t *testing.T) {
+		input := strings.Repeat("1000000007,1000000007\n", 100000)
+		for _, numeric := range []Numeric{Int, Rational, Decimal} {
+			rows := 0
+			err := Multiply(context.Background(), strings.NewReader(input), io.Discard, WithNumeric(numeric), WithMaxResultDigits(100), WithProgress(func(n int) { rows += n }))
+			assert.ErrorIs(t, err, ErrResultTooLarge, numeric)
+			assert.Less(t, rows, 100000, numeric)
+		}
+	})
+
+	t.Run("Blocks of a product", func(t *testing.T) {
+		a := Input{Reader: strings.NewReader("%%MatrixMarket matrix coordinate integer general\n100 100 0\n"), Format: MatrixMarket}
+		b := Input{Reader: strings.NewReader("%%MatrixMarket matrix coordinate integer general\n100 1 0\n"), Format: MatrixMarket}
+		err := MatMul(context.Background(), a, b, io.Discard, WithTempDir(t.TempDir()), WithMemoryBudget(64*1000))
+		var got *Error
+		if assert.ErrorAs(t, err, &got) {
+			assert.Equal(t, CodeTooManyCells, got.Code)
+		}
+	})
+
+	t.Run("Cancelled while reading the entries", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		src := Input{Reader: strings.NewReader("%%MatrixMarket matrix coordinate integer general\n2 2 1\n1 1 5\n"), Format: MatrixMarket}
+		_, err := newReader(ctx, src, newConfig(nil)).Read()
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.False(t, IsInputError(err))
+	})
+
+	t.Run("Cells of the result", func(t *testing.T) {
+		err := MatMul(context.Background(), strings.NewReader("10"), strings.NewReader("10"), io.Discard, WithMaxResultDigits(2))
+		assert.ErrorIs(t, err, ErrResultTooLarge)
+		assert.ErrorContains(t, err, "in row 1, column 1 of the result")
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		_, err := Validate(context.Background(), strings.NewReader("1\n2\n3"), WithMaxRows(2))
+		assert.ErrorIs(t, err, ErrTooLarge)
+	})
+
+	t.Run("Within the limits", func(t *testing.T) {
+		var out bytes.Buffer
+		opts := []Option{WithMaxRows(2), WithMaxColumns(2), WithMaxCellLength(2), WithMaxResultDigits(4)}
+		assert.NoError(t, Multiply(context.Background(), strings.NewReader("99,99\n1,1"), &out, opts...))
+		assert.Equal(t, "9801\n", out.String())
+	})
+}
+
+// failingReader fails with err once its content is read
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if errors.Is(err, io.EOF) {
+		return n, f.err
+	}
+	return n, err
+}
+
+func TestReadErrorCause(t *testing.T) {
+	cause := errors.New("connection reset")
+	err := Sum(context.Background(), &failingReader{strings.NewReader("1,2\n3,"), cause}, io.Discard)
+	assert.ErrorIs(t, err, ErrSyntax)
+	assert.ErrorIs(t, err, cause)
+}
+
+func TestCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := Echo(ctx, strings.NewReader("1"), io.Discard)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.False(t, IsInputError(err))
+}
+
+// randomMatrix is the 2000 × 2000 matrix BigCVS generates, with a fixed seed
+var randomMatrix = sync.OnceValue(func() string {
+	var input strings.Builder
+	if err := WriteRandom(&input, 2000, 2000, rand.New(rand.NewSource(1))); err != nil {
+		panic(err)
+	}
+	return input.String()
+})
+
+func BenchmarkSum(b *testing.B) {
+	input, _ := testMatrix(1000, 200)
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("%d workers", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				if err := Sum(context.Background(), strings.NewReader(input), io.Discard, WithConcurrency(workers)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	input = randomMatrix()
+	b.Run("2000x2000 int64", func(b *testing.B) {
+		b.SetBytes(int64(len(input)))
+		for i := 0; i < b.N; i++ {
+			if _, err := sumInts(context.Background(), NewReader(strings.NewReader(input)), 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("2000x2000 big.Int", func(b *testing.B) {
+		b.SetBytes(int64(len(input)))
+		ar := intArithmetic{numberFormat{places: -1}}
+		for i := 0; i < b.N; i++ {
+			if _, err := sumOf(context.Background(), NewReader(strings.NewReader(input)), ar, 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkMultiply(b *testing.B) {
+	// the big.Int product grows by a few bits per cell and costs its length at
+	// every cell, so it only gets the first rows of the 2000 × 2000 matrix
+	input := randomMatrix()
+	for _, rows := range []int{50, 2000} {
+		rowsInput := input
+		for i, n := 0, 0; i < len(input); i++ {
+			if input[i] == '\n' {
+				if n++; n == rows {
+					rowsInput = input[:i+1]
+					break
+				}
+			}
+		}
+		b.Run(fmt.Sprintf("%dx2000 int64", rows), func(b *testing.B) {
+			b.SetBytes(int64(len(rowsInput)))
+			for i := 0; i < b.N; i++ {
+				if _, err := productInts(context.Background(), NewReader(strings.NewReader(rowsInput)), 1, limits{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		if rows > 50 {
+			continue
+		}
+		b.Run(fmt.Sprintf("%dx2000 big.Int", rows), func(b *testing.B) {
+			b.SetBytes(int64(len(rowsInput)))
+			ar := intArithmetic{numberFormat{places: -1}}
+			for i := 0; i < b.N; i++ {
+				if _, err := productOf(context.Background(), NewReader(strings.NewReader(rowsInput)), ar, 1, limits{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
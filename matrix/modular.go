@@ -0,0 +1,205 @@
+package matrix
+
+import (
+	"context"
+	"math/big"
+)
+
+// WithModulus makes the Int mode compute in Z/nZ: every cell is reduced
+// modulo n when parsed and every result is reduced too, so products and
+// determinants of large matrices stay the size of n. Results are written as
+// their representative between 0 and n-1. Moduli lower than 1 are ignored.
+func WithModulus(n *big.Int) Option {
+	return func(c *config) {
+		if n != nil && n.Sign() > 0 {
+			c.modulus = new(big.Int).Set(n)
+		} else {
+			c.modulus = nil
+		}
+	}
+}
+
+// modArithmetic computes with the integers modulo n, represented between 0
+// and n-1. quo is only defined when y is invertible modulo n.
+type modArithmetic struct {
+	numberFormat
+	n *big.Int
+}
+
+func (modArithmetic) zero() *big.Int { return new(big.Int) }
+func (a modArithmetic) parse(z *big.Int, cell string) (*big.Int, error) {
+	if err := parseInt(z, cell); err != nil {
+		return z, err
+	}
+	return z.Mod(z, a.n), nil
+}
+func (a modArithmetic) setInt64(z *big.Int, x int64) *big.Int { return z.Mod(z.SetInt64(x), a.n) }
+func (modArithmetic) set(z, x *big.Int) *big.Int              { return z.Set(x) }
+func (a modArithmetic) add(z, x, y *big.Int) *big.Int         { return z.Mod(z.Add(x, y), a.n) }
+func (a modArithmetic) sub(z, x, y *big.Int) *big.Int         { return z.Mod(z.Sub(x, y), a.n) }
+func (a modArithmetic) mul(z, x, y *big.Int) *big.Int         { return z.Mod(z.Mul(x, y), a.n) }
+func (a modArithmetic) quo(z, x, y *big.Int) *big.Int {
+	inv := new(big.Int).ModInverse(y, a.n)
+	return a.mul(z, x, inv)
+}
+func (modArithmetic) abs(z, x *big.Int) *big.Int  { return z.Set(x) }
+func (modArithmetic) cmp(x, y *big.Int) int       { return x.Cmp(y) }
+func (modArithmetic) sign(x *big.Int) int         { return x.Sign() }
+func (modArithmetic) epsilon(z *big.Int) *big.Int { return z.SetInt64(0) }
+func (a modArithmetic) format(x *big.Int) string  { return intArithmetic{a.numberFormat}.format(x) }
+func (modArithmetic) bits(x *big.Int) int         { return intArithmetic{}.bits(x) }
+
+// newModEngine returns the engine of the Int mode modulo n
+func newModEngine(ar modArithmetic, workers int) *numericEngine[*big.Int] {
+	return &numericEngine[*big.Int]{
+		ar:      ar,
+		workers: workers,
+		det: func(ctx context.Context, m [][]*big.Int) (*big.Int, error) {
+			return modDeterminant(ctx, m, ar)
+		},
+		rank: func(ctx context.Context, m [][]*big.Int) (int, error) {
+			if len(m) == 0 {
+				return 0, nil
+			}
+			rank, _, err := modEchelon(ctx, m, len(m[0]), ar)
+			return rank, err
+		},
+		invert: func(ctx context.Context, m [][]*big.Int, w RowWriter) error {
+			inv, err := modInverse(ctx, m, ar)
+			if err != nil {
+				return err
+			}
+			row := make([]string, len(inv))
+			for _, cells := range inv {
+				for j, cell := range cells {
+					row[j] = ar.format(cell)
+				}
+				if err = w.WriteRow(row); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// modEchelon reduces the first cols columns of m to a row echelon form with
+// the row operations of Z/nZ that need no division: the pivot of a column is
+// found by running Euclid's algorithm on the rows, subtracting multiples of
+// the row with the smallest value until every other value of the column is
+// 0, so any modulus works, prime or not. It returns the number of pivots, the
+// rank when n is prime, and whether the rows were swapped an odd number of
+// times. m is overwritten, the columns after cols follow the row operations.
+func modEchelon(ctx context.Context, m [][]*big.Int, cols int, ar modArithmetic) (int, bool, error) {
+	rows := len(m)
+	rank, odd := 0, false
+	q, tmp := new(big.Int), new(big.Int)
+	for col := 0; col < cols && rank < rows; col++ {
+		for {
+			// a single step is quadratic, check the deadline for every round
+			if err := ctx.Err(); err != nil {
+				return 0, false, err
+			}
+
+			// bring the smallest non zero value of the column to the pivot
+			pivot := -1
+			for i := rank; i < rows; i++ {
+				if m[i][col].Sign() != 0 && (pivot < 0 || m[i][col].Cmp(m[pivot][col]) < 0) {
+					pivot = i
+				}
+			}
+			if pivot < 0 {
+				break // the column is null below the rows of the pivots
+			}
+			if pivot != rank {
+				m[rank], m[pivot] = m[pivot], m[rank]
+				odd = !odd
+			}
+
+			// leave the remainders of the euclidean division by the pivot
+			done := true
+			for i := rank + 1; i < rows; i++ {
+				if m[i][col].Sign() == 0 {
+					continue
+				}
+				q.Quo(m[i][col], m[rank][col])
+				for j := col; j < len(m[i]); j++ {
+					m[i][j] = ar.sub(m[i][j], m[i][j], tmp.Mul(q, m[rank][j]))
+				}
+				if m[i][col].Sign() != 0 {
+					done = false
+				}
+			}
+			if done {
+				rank++
+				break
+			}
+		}
+	}
+	return rank, odd, nil
+}
+
+// modDeterminant computes the determinant of the square matrix m modulo n as
+// the product of the pivots of its row echelon form. m is overwritten.
+func modDeterminant(ctx context.Context, m [][]*big.Int, ar modArithmetic) (*big.Int, error) {
+	rank, odd, err := modEchelon(ctx, m, len(m), ar)
+	if err != nil {
+		return nil, err
+	}
+	det := ar.setInt64(ar.zero(), 1)
+	if rank < len(m) {
+		return det.SetInt64(0), nil
+	}
+	for k := range m {
+		det = ar.mul(det, det, m[k][k])
+	}
+	if odd {
+		det = ar.sub(det, ar.zero(), det)
+	}
+	return det, nil
+}
+
+// modInverse inverts the square matrix m modulo n by reducing [m | I] to a
+// row echelon form, then dividing by the pivots from the last row up. The
+// inverse exists when every pivot, hence the determinant, is invertible
+// modulo n. m is overwritten.
+func modInverse(ctx context.Context, m [][]*big.Int, ar modArithmetic) ([][]*big.Int, error) {
+	n := len(m)
+	for i, row := range m {
+		aug := make([]*big.Int, 2*n)
+		copy(aug, row)
+		for j := n; j < 2*n; j++ {
+			aug[j] = new(big.Int)
+		}
+		aug[n+i].SetInt64(1)
+		m[i] = aug
+	}
+
+	if _, _, err := modEchelon(ctx, m, n, ar); err != nil {
+		return nil, err
+	}
+	inv, factor, tmp := new(big.Int), new(big.Int), new(big.Int)
+	for k := n - 1; k >= 0; k-- {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if inv.ModInverse(m[k][k], ar.n) == nil {
+			return nil, newInputError(ErrSingular, "matrix is singular modulo %s: column %d has no invertible pivot", ar.n, k+1)
+		}
+		for j := k; j < 2*n; j++ {
+			m[k][j] = ar.mul(m[k][j], m[k][j], inv)
+		}
+		// clear the column above the pivot
+		for i := 0; i < k; i++ {
+			factor.Set(m[i][k])
+			for j := k; j < 2*n; j++ {
+				m[i][j] = ar.sub(m[i][j], m[i][j], tmp.Mul(factor, m[k][j]))
+			}
+		}
+	}
+
+	for i := range m {
+		m[i] = m[i][n:]
+	}
+	return m, nil
+}
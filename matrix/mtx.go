@@ -0,0 +1,638 @@
+package matrix
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const mtxBanner = "%%MatrixMarket"
+
+// largest number of rows or columns of a MatrixMarket file, the indices of
+// the format are 32-bit integers
+const maxMTXSize = math.MaxInt32
+
+// widest matrix whose rows are densified, each costs a buffer of that many
+// cells
+const maxDenseCols = 1 << 24
+
+// estimate of the bytes of a cell of a matrix held in memory, its number and
+// its slot in the row
+const denseCellBytes = 64
+
+// mtxHeader describes a MatrixMarket file, from its banner and size lines
+type mtxHeader struct {
+	coordinate bool   // coordinate or array layout
+	field      string // integer, real or pattern
+	symmetric  bool   // only the lower triangle is stored
+	rows, cols int
+	entries    int // number of stored entries
+}
+
+// banner returns the first line of a MatrixMarket file with this header
+func (h *mtxHeader) banner() string {
+	symmetry := "general"
+	if h.symmetric {
+		symmetry = "symmetric"
+	}
+	return fmt.Sprintf("%s matrix coordinate %s %s\n", mtxBanner, h.field, symmetry)
+}
+
+// mtxEntry is a stored cell, with 0-based indices
+type mtxEntry struct {
+	row, col int
+	value    string
+}
+
+// mtxReader streams the entries of a MatrixMarket file without densifying it.
+// Entries of symmetric matrices are mirrored above the diagonal when expand
+// is set.
+type mtxReader struct {
+	r      *bufio.Reader
+	header mtxHeader
+	expand bool
+	line   int
+	read   int // stored entries read
+	mirror *mtxEntry
+	// next position of an array file, stored column by column
+	row, col int
+}
+
+// newMTXReader reads the header of a MatrixMarket file, the rows it declares
+// are checked against l before any entry is read. The columns are only
+// checked by checkDense, as the entries of a wide matrix are not held in rows.
+func newMTXReader(r *bufio.Reader, expand bool, l limits) (*mtxReader, error) {
+	m := &mtxReader{r: r, expand: expand}
+	if err := m.readHeader(); err != nil {
+		return nil, err
+	}
+	if err := l.checkShape(m.header.rows, 0); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// nextLine returns the next line which is neither blank nor a comment
+func (m *mtxReader) nextLine() (string, error) {
+	for {
+		line, err := m.r.ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			return "", err
+		}
+		m.line++
+		line = strings.TrimSpace(line)
+		if line != "" && line[0] != '%' {
+			return line, nil
+		}
+	}
+}
+
+func (m *mtxReader) readHeader() error {
+	banner, err := m.r.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	m.line++
+	fields := strings.Fields(strings.ToLower(banner))
+	if len(fields) != 5 || fields[0] != strings.ToLower(mtxBanner) || fields[1] != "matrix" {
+		return m.syntaxError("expects a %s matrix banner", mtxBanner)
+	}
+
+	h := &m.header
+	switch fields[2] {
+	case "coordinate":
+		h.coordinate = true
+	case "array":
+	default:
+		return m.syntaxError("unsupported layout %q, expects coordinate or array", fields[2])
+	}
+	switch fields[3] {
+	case "integer", "real", "pattern":
+		h.field = fields[3]
+	default:
+		return m.syntaxError("unsupported field %q, expects integer, real or pattern", fields[3])
+	}
+	if h.field == "pattern" && !h.coordinate {
+		return m.syntaxError("pattern field requires the coordinate layout")
+	}
+	switch fields[4] {
+	case "general":
+	case "symmetric":
+		h.symmetric = true
+	default:
+		return m.syntaxError("unsupported symmetry %q, expects general or symmetric", fields[4])
+	}
+
+	line, err := m.nextLine()
+	if err != nil {
+		return m.syntaxError("missing size line")
+	}
+	sizes := strings.Fields(line)
+	want := 2
+	if h.coordinate {
+		want = 3
+	}
+	if len(sizes) != want {
+		return m.syntaxError("size line expects %d numbers", want)
+	}
+	values := make([]int, want)
+	for i, size := range sizes {
+		if values[i], err = strconv.Atoi(size); err != nil || values[i] < 0 {
+			return m.syntaxError("invalid size %q", size)
+		}
+		if values[i] > maxMTXSize {
+			return m.syntaxError("size %d is larger than %d", values[i], maxMTXSize)
+		}
+	}
+	h.rows, h.cols = values[0], values[1]
+	if h.symmetric && h.rows != h.cols {
+		return m.syntaxError("symmetric matrix must be square, found %d x %d", h.rows, h.cols)
+	}
+	switch {
+	case h.coordinate:
+		h.entries = values[2]
+	case h.symmetric:
+		h.entries = h.rows * (h.rows + 1) / 2
+	default:
+		h.entries = h.rows * h.cols
+	}
+	return nil
+}
+
+// checkDense reports rows of cols cells too wide to be densified
+func checkDense(cols int, l limits) error {
+	if err := l.checkShape(0, cols); err != nil {
+		return err
+	}
+	if cols > maxDenseCols {
+		return newLimitError(CodeTooManyColumns, "%d columns, more than the %d of a dense row", cols, maxDenseCols)
+	}
+	return nil
+}
+
+func (m *mtxReader) syntaxError(format string, args ...any) error {
+	return newInputError(ErrSyntax, "MTX parsing error: line %d: %s", m.line, fmt.Sprintf(format, args...))
+}
+
+// Next returns the next entry, or io.EOF once every declared entry is read.
+func (m *mtxReader) Next() (mtxEntry, error) {
+	if m.mirror != nil {
+		entry := *m.mirror
+		m.mirror = nil
+		return entry, nil
+	}
+	if m.read == m.header.entries {
+		if _, err := m.nextLine(); err == nil {
+			return mtxEntry{}, m.syntaxError("more entries than the %d declared", m.header.entries)
+		}
+		return mtxEntry{}, io.EOF
+	}
+
+	line, err := m.nextLine()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return mtxEntry{}, m.syntaxError("found %d entries, %d declared", m.read, m.header.entries)
+		}
+		return mtxEntry{}, err
+	}
+	m.read++
+
+	var entry mtxEntry
+	if m.header.coordinate {
+		if entry, err = m.parseCoordinate(line); err != nil {
+			return mtxEntry{}, err
+		}
+	} else {
+		entry = mtxEntry{row: m.row, col: m.col, value: line}
+		// move down the column, symmetric files only store the lower triangle
+		m.row++
+		if m.row == m.header.rows {
+			m.col++
+			m.row = 0
+			if m.header.symmetric {
+				m.row = m.col
+			}
+		}
+	}
+
+	if m.expand && m.header.symmetric && entry.row != entry.col {
+		m.mirror = &mtxEntry{row: entry.col, col: entry.row, value: entry.value}
+	}
+	return entry, nil
+}
+
+func (m *mtxReader) parseCoordinate(line string) (mtxEntry, error) {
+	fields := strings.Fields(line)
+	want := 3
+	if m.header.field == "pattern" {
+		want = 2
+	}
+	if len(fields) != want {
+		return mtxEntry{}, m.syntaxError("expects %d values per entry, found %d", want, len(fields))
+	}
+	row, rerr := strconv.Atoi(fields[0])
+	col, cerr := strconv.Atoi(fields[1])
+	if rerr != nil || cerr != nil || row < 1 || row > m.header.rows || col < 1 || col > m.header.cols {
+		return mtxEntry{}, m.syntaxError("entry (%s, %s) is outside the %d x %d matrix", fields[0], fields[1], m.header.rows, m.header.cols)
+	}
+	if m.header.symmetric && col > row {
+		return mtxEntry{}, m.syntaxError("symmetric matrix stores the lower triangle, found entry (%d, %d)", row, col)
+	}
+	entry := mtxEntry{row: row - 1, col: col - 1, value: "1"}
+	if want == 3 {
+		entry.value = fields[2]
+	}
+	return entry, nil
+}
+
+// readEntries loads every entry, mirrored for symmetric files, and sorts them
+// by row then column. The memory grows with the number of entries only.
+func (m *mtxReader) readEntries(ctx context.Context, transpose bool) ([]mtxEntry, error) {
+	var entries []mtxEntry
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		entry, err := m.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if transpose {
+			entry.row, entry.col = entry.col, entry.row
+		}
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b mtxEntry) int {
+		if c := cmp.Compare(a.row, b.row); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.col, b.col)
+	})
+	for i := 1; i < len(entries); i++ {
+		if entries[i].row == entries[i-1].row && entries[i].col == entries[i-1].col {
+			err := newInputError(ErrSyntax, "MTX parsing error: duplicate entry (%d, %d)", entries[i].row+1, entries[i].col+1)
+			err.Row, err.Col = entries[i].row+1, entries[i].col+1
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// writeDenseRows writes sorted entries as dense rows, filling the gaps with 0
+func writeDenseRows(ctx context.Context, entries []mtxEntry, rows, cols int, w RowWriter) error {
+	row := make([]string, cols)
+	for i := 0; i < rows; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for j := range row {
+			row[j] = "0"
+		}
+		for len(entries) > 0 && entries[0].row == i {
+			row[entries[0].col] = entries[0].value
+			entries = entries[1:]
+		}
+		if err := w.WriteRow(row); err != nil {
+			return err
+		}
+		if (i+1)%flushRows == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Close()
+}
+
+// mtxRowSource gives the rows of a MatrixMarket file to a Reader, so every
+// operation accepts the format. Only the stored entries are kept in memory.
+type mtxRowSource struct {
+	ctx     context.Context
+	r       *bufio.Reader
+	limits  limits
+	m       *mtxReader
+	entries []mtxEntry
+	row     int
+	record  []string
+
+	// the rows the operation holds at once, all of them when 0, and the
+	// memory they may take, no limit when 0
+	heldRows int
+	budget   int64
+}
+
+func (s *mtxRowSource) Read() ([]string, error) {
+	if s.m == nil {
+		m, err := newMTXReader(s.r, true, s.limits)
+		if err != nil {
+			return nil, err
+		}
+		if err = checkDense(m.header.cols, s.limits); err != nil {
+			return nil, err
+		}
+		if err = s.checkHeld(m.header.rows, m.header.cols); err != nil {
+			return nil, err
+		}
+		if s.entries, err = m.readEntries(s.ctx, false); err != nil {
+			return nil, err
+		}
+		s.m = m
+		s.record = make([]string, m.header.cols)
+	}
+	if s.row == s.m.header.rows {
+		return nil, io.EOF
+	}
+	for j := range s.record {
+		s.record[j] = "0"
+	}
+	for len(s.entries) > 0 && s.entries[0].row == s.row {
+		s.record[s.entries[0].col] = s.entries[0].value
+		s.entries = s.entries[1:]
+	}
+	s.row++
+	return s.record, nil
+}
+
+// checkHeld reports a matrix whose dense rows held by the operation take more
+// than the memory budget, before any entry is read
+func (s *mtxRowSource) checkHeld(rows, cols int) error {
+	if s.budget <= 0 {
+		return nil
+	}
+	if s.heldRows > 0 {
+		rows = min(rows, s.heldRows)
+	}
+	if cells := int64(rows) * int64(cols); cells > s.budget/denseCellBytes {
+		return newLimitError(CodeTooManyCells, "%d x %d cells held in memory, more than the %d bytes of the memory budget", rows, cols, s.budget)
+	}
+	return nil
+}
+
+// sparseInput returns a MatrixMarket reader when src is in this format
+func sparseInput(src io.Reader, cfg *config, expand bool) (*mtxReader, bool, error) {
+	src, format := resolveInput(src, cfg)
+	if format != MatrixMarket {
+		return nil, false, nil
+	}
+	m, err := newMTXReader(bufio.NewReaderSize(src, cfg.readBuffer), expand, cfg.limits)
+	return m, true, err
+}
+
+// sumEntries adds the stored entries, mirrored ones included
+func sumEntries[T any](ctx context.Context, m *mtxReader, ar arithmetic[T]) (T, error) {
+	sum, tmp := ar.zero(), ar.zero()
+	for {
+		if err := ctx.Err(); err != nil {
+			return sum, err
+		}
+		entry, err := m.Next()
+		if errors.Is(err, io.EOF) {
+			return sum, nil
+		}
+		if err != nil {
+			return sum, err
+		}
+		if tmp, err = ar.parse(tmp, entry.value); err != nil {
+			return sum, locate(err, entry.row+1, entry.col+1)
+		}
+		sum = ar.add(sum, sum, tmp)
+	}
+}
+
+// productEntries multiplies the stored entries, the product is 0 as soon as a
+// single cell is not stored
+func productEntries[T any](ctx context.Context, m *mtxReader, ar arithmetic[T], l limits) (T, error) {
+	product, tmp := ar.setInt64(ar.zero(), 1), ar.zero()
+	count := 0
+	// with fewer entries than cells the product is 0, however large the
+	// entries multiplied
+	h := m.header
+	full := h.entries >= h.rows*h.cols || h.symmetric && 2*h.entries >= h.rows*h.cols
+	for {
+		if err := ctx.Err(); err != nil {
+			return product, err
+		}
+		entry, err := m.Next()
+		if errors.Is(err, io.EOF) {
+			if count < m.header.rows*m.header.cols {
+				return ar.zero(), nil
+			}
+			return product, nil
+		}
+		if err != nil {
+			return product, err
+		}
+		if tmp, err = ar.parse(tmp, entry.value); err != nil {
+			return product, locate(err, entry.row+1, entry.col+1)
+		}
+		count++
+		if product = ar.mul(product, product, tmp); ar.sign(product) == 0 {
+			return product, nil
+		}
+		if !full {
+			continue
+		}
+		if err = l.checkBits(ar.bits(product)); err != nil {
+			return product, err
+		}
+	}
+}
+
+// copySparse writes the matrix, or its transpose, from its stored entries. A
+// MatrixMarket result is streamed entry by entry, any other format needs the
+// entries sorted by row.
+func copySparse(ctx context.Context, m *mtxReader, dst io.Writer, cfg *config, transpose bool) error {
+	h := m.header
+	// the transpose of a symmetric matrix is itself
+	transpose = transpose && !h.symmetric
+	rows, cols := h.rows, h.cols
+	if transpose {
+		rows, cols = cols, rows
+	}
+
+	if cfg.format != MatrixMarket {
+		if err := checkDense(cols, cfg.limits); err != nil {
+			return err
+		}
+		m.expand = true
+		entries, err := m.readEntries(ctx, transpose)
+		if err != nil {
+			return err
+		}
+		return writeDenseRows(ctx, entries, rows, cols, cfg.rowWriter(dst))
+	}
+
+	w := bufio.NewWriterSize(dst, cfg.writeBuffer)
+	out := h
+	out.rows, out.cols = rows, cols
+	fmt.Fprint(w, out.banner())
+	fmt.Fprintf(w, "%d %d %d\n", rows, cols, h.entries)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entry, err := m.Next()
+		if errors.Is(err, io.EOF) {
+			return w.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		if transpose {
+			entry.row, entry.col = entry.col, entry.row
+		}
+		if h.field == "pattern" {
+			fmt.Fprintf(w, "%d %d\n", entry.row+1, entry.col+1)
+		} else {
+			fmt.Fprintf(w, "%d %d %s\n", entry.row+1, entry.col+1, entry.value)
+		}
+	}
+}
+
+// mtxWriter writes a result in the MatrixMarket coordinate layout. The size
+// line precedes the entries, so they are held until Close: in memory up to
+// the memory budget, then spilled into a temporary file of tempDir.
+type mtxWriter struct {
+	dst     io.Writer
+	size    int // of the write buffers
+	budget  int64
+	tempDir string
+
+	entries bytes.Buffer
+	spill   *os.File
+	spilled *bufio.Writer
+
+	row   int
+	col   int
+	cols  int
+	count int
+	real  bool
+	value big.Int // scratch value parsing the cells
+}
+
+func (m *mtxWriter) WriteRow(row []string) error {
+	if err := m.WriteCells(row); err != nil {
+		return err
+	}
+	return m.EndRow()
+}
+
+func (m *mtxWriter) WriteCells(cells []string) error {
+	for _, cell := range cells {
+		m.col++
+		if _, isInt := m.value.SetString(cell, 10); !isInt {
+			if f, err := strconv.ParseFloat(cell, 64); err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				err := newInputError(ErrNotNumber, "cell %q cannot be written in MatrixMarket format", cell)
+				err.Token, err.Row, err.Col = cell, m.row+1, m.col
+				return err
+			}
+			m.real = true
+		}
+		if isZero(cell) {
+			continue
+		}
+		m.count++
+		if m.spilled != nil {
+			if _, err := fmt.Fprintf(m.spilled, "%d %d %s\n", m.row+1, m.col, cell); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Fprintf(&m.entries, "%d %d %s\n", m.row+1, m.col, cell)
+		if int64(m.entries.Len()) > m.budget {
+			if err := m.spillEntries(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// spillEntries moves the entries held in memory into a temporary file, where
+// the next ones are written. The file is unlinked right away where the system
+// allows it, so that an operation failing before Close leaves nothing behind.
+func (m *mtxWriter) spillEntries() error {
+	file, err := os.CreateTemp(m.tempDir, "matrix_mtx_*.tmp")
+	if err != nil {
+		return fmt.Errorf("fail to create temp file: %w", err)
+	}
+	os.Remove(file.Name())
+	m.spill = file
+	m.spilled = bufio.NewWriterSize(file, m.size)
+	_, err = m.entries.WriteTo(m.spilled)
+	m.entries = bytes.Buffer{}
+	return err
+}
+
+func (m *mtxWriter) EndRow() error {
+	m.cols = max(m.cols, m.col)
+	m.col = 0
+	m.row++
+	return nil
+}
+
+// Flush does nothing, the entries can only be written once they are all known
+func (m *mtxWriter) Flush() error {
+	return nil
+}
+
+func (m *mtxWriter) Close() error {
+	if m.spill != nil {
+		defer func() {
+			m.spill.Close()
+			os.Remove(m.spill.Name()) // where it could not be unlinked before
+		}()
+	}
+	h := mtxHeader{coordinate: true, field: "integer"}
+	if m.real {
+		h.field = "real"
+	}
+	w := bufio.NewWriterSize(m.dst, m.size)
+	fmt.Fprint(w, h.banner())
+	fmt.Fprintf(w, "%d %d %d\n", m.row, m.cols, m.count)
+	if err := m.copyEntries(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if f, ok := m.dst.(flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// copyEntries writes the entries to w, from memory or from the spill file
+func (m *mtxWriter) copyEntries(w io.Writer) error {
+	if m.spill == nil {
+		_, err := m.entries.WriteTo(w)
+		return err
+	}
+	if err := m.spilled.Flush(); err != nil {
+		return err
+	}
+	if _, err := m.spill.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, m.spill)
+	return err
+}
+
+// isZero reports whether a numeric cell such as "0", "-0" or "0.00" is zero
+func isZero(cell string) bool {
+	digits := strings.TrimLeft(cell, "+-")
+	return digits != "" && strings.Trim(digits, "0.") == ""
+}
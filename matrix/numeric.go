@@ -0,0 +1,484 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Numeric is the kind of numbers the cells are parsed as and computed with.
+type Numeric string
+
+const (
+	Int      Numeric = "int"      // integers of any size, exact
+	Decimal  Numeric = "decimal"  // big.Float of WithPrecision bits, rounded with WithRounding
+	Rational Numeric = "rational" // fractions of integers of any size, exact
+	Float64  Numeric = "float64"  // IEEE 754 double precision
+)
+
+// Numerics lists the supported numeric modes.
+var Numerics = []Numeric{Int, Decimal, Rational, Float64}
+
+// ParseNumeric returns the numeric mode named s, such as "int" or "decimal".
+func ParseNumeric(s string) (Numeric, error) {
+	for _, n := range Numerics {
+		if strings.EqualFold(s, string(n)) {
+			return n, nil
+		}
+	}
+	names := make([]string, len(Numerics))
+	for i, n := range Numerics {
+		names[i] = string(n)
+	}
+	return "", fmt.Errorf("unsupported numeric mode %q, expected one of %s", s, strings.Join(names, ", "))
+}
+
+// rounding modes by the names accepted by ParseRounding
+var roundings = []struct {
+	name string
+	mode big.RoundingMode
+}{
+	{"half_even", big.ToNearestEven},
+	{"half_up", big.ToNearestAway},
+	{"down", big.ToZero},
+	{"up", big.AwayFromZero},
+	{"floor", big.ToNegativeInf},
+	{"ceiling", big.ToPositiveInf},
+}
+
+// ParseRounding returns the rounding mode named s: half_even, half_up (ties
+// away from zero), down (toward zero), up (away from zero), floor or ceiling.
+func ParseRounding(s string) (big.RoundingMode, error) {
+	names := make([]string, len(roundings))
+	for i, r := range roundings {
+		if strings.EqualFold(s, r.name) {
+			return r.mode, nil
+		}
+		names[i] = r.name
+	}
+	return 0, fmt.Errorf("unsupported rounding %q, expected one of %s", s, strings.Join(names, ", "))
+}
+
+// arithmetic implements the operations of a numeric mode on its number type.
+// Like math/big, the operations set and return their receiver z, a value
+// type such as float64 only returns the result, so callers always keep the
+// returned value.
+type arithmetic[T any] interface {
+	// zero returns a new number set to 0
+	zero() T
+	parse(z T, cell string) (T, error)
+	setInt64(z T, x int64) T
+	set(z, x T) T
+	add(z, x, y T) T
+	sub(z, x, y T) T
+	mul(z, x, y T) T
+	// quo divides by a non zero y, truncating in the int mode
+	quo(z, x, y T) T
+	abs(z, x T) T
+	cmp(x, y T) int
+	sign(x T) int
+	// epsilon returns the relative error of one operation, 0 when exact
+	epsilon(z T) T
+	format(x T) string
+	// bits returns a lower bound of the bits of the digits x is written
+	// with, so that a result can be checked against WithMaxResultDigits
+	// while it is computed
+	bits(x T) int
+}
+
+// numberFormat is how results are written back: with exactly places
+// decimals rounded with mode, or as computed when places is negative
+type numberFormat struct {
+	places int
+	mode   big.RoundingMode
+}
+
+// fixed formats x with the configured number of decimals
+func (f numberFormat) fixed(x *big.Rat) string {
+	return formatDecimal(x, f.places, f.mode)
+}
+
+// formatDecimal formats x with exactly places decimals, rounded with mode
+func formatDecimal(x *big.Rat, places int, mode big.RoundingMode) string {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places)), nil)
+	num := new(big.Int).Mul(x.Num(), scale)
+	q, r := new(big.Int).QuoRem(num, x.Denom(), new(big.Int))
+	if r.Sign() != 0 {
+		// compare the dropped part with one half
+		half := r.Abs(r).Lsh(r, 1).Cmp(x.Denom())
+		away := false
+		switch mode {
+		case big.ToNearestEven:
+			away = half > 0 || half == 0 && q.Bit(0) == 1
+		case big.ToNearestAway:
+			away = half >= 0
+		case big.AwayFromZero:
+			away = true
+		case big.ToNegativeInf:
+			away = num.Sign() < 0
+		case big.ToPositiveInf:
+			away = num.Sign() > 0
+		}
+		if away {
+			q.Add(q, big.NewInt(int64(num.Sign())))
+		}
+	}
+
+	digits := new(big.Int).Abs(q).String()
+	if places > 0 {
+		if len(digits) <= places {
+			digits = strings.Repeat("0", places-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-places] + "." + digits[len(digits)-places:]
+	}
+	if q.Sign() < 0 {
+		return "-" + digits
+	}
+	return digits
+}
+
+// parseFraction parses cells such as "2/3" for the modes rounding them
+func parseFraction(cell string) (*big.Rat, bool) {
+	if !strings.Contains(cell, "/") {
+		return nil, false
+	}
+	return new(big.Rat).SetString(cell)
+}
+
+// intArithmetic computes with big.Int
+type intArithmetic struct{ numberFormat }
+
+func (intArithmetic) zero() *big.Int { return new(big.Int) }
+func (intArithmetic) parse(z *big.Int, cell string) (*big.Int, error) {
+	return z, parseInt(z, cell)
+}
+func (intArithmetic) setInt64(z *big.Int, x int64) *big.Int { return z.SetInt64(x) }
+func (intArithmetic) set(z, x *big.Int) *big.Int            { return z.Set(x) }
+func (intArithmetic) add(z, x, y *big.Int) *big.Int         { return z.Add(x, y) }
+func (intArithmetic) sub(z, x, y *big.Int) *big.Int         { return z.Sub(x, y) }
+func (intArithmetic) mul(z, x, y *big.Int) *big.Int         { return z.Mul(x, y) }
+func (intArithmetic) quo(z, x, y *big.Int) *big.Int         { return z.Quo(x, y) }
+func (intArithmetic) abs(z, x *big.Int) *big.Int            { return z.Abs(x) }
+func (intArithmetic) cmp(x, y *big.Int) int                 { return x.Cmp(y) }
+func (intArithmetic) sign(x *big.Int) int                   { return x.Sign() }
+func (intArithmetic) epsilon(z *big.Int) *big.Int           { return z.SetInt64(0) }
+func (a intArithmetic) format(x *big.Int) string {
+	if a.places < 0 {
+		return x.String()
+	}
+	return a.fixed(new(big.Rat).SetInt(x))
+}
+func (intArithmetic) bits(x *big.Int) int { return max(x.BitLen()-1, 0) }
+
+// ratArithmetic computes with big.Rat, cells such as "1.5" or "2/3" are exact
+type ratArithmetic struct{ numberFormat }
+
+func (ratArithmetic) zero() *big.Rat { return new(big.Rat) }
+func (ratArithmetic) parse(z *big.Rat, cell string) (*big.Rat, error) {
+	if _, ok := z.SetString(strings.TrimSpace(cell)); !ok {
+		return z, notNumber(cell)
+	}
+	return z, nil
+}
+func (ratArithmetic) setInt64(z *big.Rat, x int64) *big.Rat { return z.SetInt64(x) }
+func (ratArithmetic) set(z, x *big.Rat) *big.Rat            { return z.Set(x) }
+func (ratArithmetic) add(z, x, y *big.Rat) *big.Rat         { return z.Add(x, y) }
+func (ratArithmetic) sub(z, x, y *big.Rat) *big.Rat         { return z.Sub(x, y) }
+func (ratArithmetic) mul(z, x, y *big.Rat) *big.Rat         { return z.Mul(x, y) }
+func (ratArithmetic) quo(z, x, y *big.Rat) *big.Rat         { return z.Quo(x, y) }
+func (ratArithmetic) abs(z, x *big.Rat) *big.Rat            { return z.Abs(x) }
+func (ratArithmetic) cmp(x, y *big.Rat) int                 { return x.Cmp(y) }
+func (ratArithmetic) sign(x *big.Rat) int                   { return x.Sign() }
+func (ratArithmetic) epsilon(z *big.Rat) *big.Rat           { return z.SetInt64(0) }
+func (a ratArithmetic) format(x *big.Rat) string {
+	if a.places < 0 {
+		return x.RatString()
+	}
+	return a.fixed(x)
+}
+func (a ratArithmetic) bits(x *big.Rat) int {
+	num, denom := x.Num().BitLen(), x.Denom().BitLen()
+	if a.places >= 0 {
+		// only the integer part is sure to be written in full
+		return max(num-denom-1, 0)
+	}
+	if x.IsInt() {
+		return max(num-1, 0)
+	}
+	return max(num-1, 0) + max(denom-1, 0)
+}
+
+// floatArithmetic computes with big.Float of a fixed precision, every
+// operation being rounded with the configured mode
+type floatArithmetic struct {
+	numberFormat
+	prec     uint
+	rounding big.RoundingMode
+}
+
+func (a floatArithmetic) zero() *big.Float {
+	return new(big.Float).SetPrec(a.prec).SetMode(a.rounding)
+}
+func (floatArithmetic) parse(z *big.Float, cell string) (*big.Float, error) {
+	cell = strings.TrimSpace(cell)
+	if r, ok := parseFraction(cell); ok {
+		return z.SetRat(r), nil
+	}
+	if _, ok := z.SetString(cell); !ok || z.IsInf() {
+		return z, notNumber(cell)
+	}
+	return z, nil
+}
+func (floatArithmetic) setInt64(z *big.Float, x int64) *big.Float { return z.SetInt64(x) }
+func (floatArithmetic) set(z, x *big.Float) *big.Float            { return z.Set(x) }
+func (floatArithmetic) add(z, x, y *big.Float) *big.Float         { return z.Add(x, y) }
+func (floatArithmetic) sub(z, x, y *big.Float) *big.Float         { return z.Sub(x, y) }
+func (floatArithmetic) mul(z, x, y *big.Float) *big.Float         { return z.Mul(x, y) }
+func (floatArithmetic) quo(z, x, y *big.Float) *big.Float         { return z.Quo(x, y) }
+func (floatArithmetic) abs(z, x *big.Float) *big.Float            { return z.Abs(x) }
+func (floatArithmetic) cmp(x, y *big.Float) int                   { return x.Cmp(y) }
+func (floatArithmetic) sign(x *big.Float) int                     { return x.Sign() }
+func (a floatArithmetic) epsilon(z *big.Float) *big.Float {
+	return z.SetMantExp(big.NewFloat(1), -int(a.prec))
+}
+func (a floatArithmetic) format(x *big.Float) string {
+	if a.places < 0 || x.IsInf() {
+		return x.Text('f', -1)
+	}
+	// round the shortest decimal that identifies x, as written without
+	// places, rather than its binary value: 6.405 stays a tie
+	r, _ := new(big.Rat).SetString(x.Text('e', -1))
+	return a.fixed(r)
+}
+func (floatArithmetic) bits(x *big.Float) int {
+	if x.IsInf() {
+		return 0
+	}
+	// the integer part, of |x| >= 2^(exp-1)
+	return max(x.MantExp(nil)-1, 0)
+}
+
+// float64Arithmetic computes with float64
+type float64Arithmetic struct{ numberFormat }
+
+func (float64Arithmetic) zero() float64 { return 0 }
+func (float64Arithmetic) parse(_ float64, cell string) (float64, error) {
+	cell = strings.TrimSpace(cell)
+	if r, ok := parseFraction(cell); ok {
+		f, _ := r.Float64()
+		return f, nil
+	}
+	f, err := strconv.ParseFloat(cell, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, notNumber(cell)
+	}
+	return f, nil
+}
+func (float64Arithmetic) setInt64(_ float64, x int64) float64 { return float64(x) }
+func (float64Arithmetic) set(_, x float64) float64            { return x }
+func (float64Arithmetic) add(_, x, y float64) float64         { return x + y }
+func (float64Arithmetic) sub(_, x, y float64) float64         { return x - y }
+func (float64Arithmetic) mul(_, x, y float64) float64         { return x * y }
+func (float64Arithmetic) quo(_, x, y float64) float64         { return x / y }
+func (float64Arithmetic) abs(_, x float64) float64            { return math.Abs(x) }
+func (float64Arithmetic) cmp(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+func (a float64Arithmetic) sign(x float64) int      { return a.cmp(x, 0) }
+func (float64Arithmetic) epsilon(_ float64) float64 { return 0x1p-52 }
+func (a float64Arithmetic) format(x float64) string {
+	if a.places < 0 || math.IsInf(x, 0) || math.IsNaN(x) {
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(x, 'e', -1, 64))
+	return a.fixed(r)
+}
+func (float64Arithmetic) bits(x float64) int {
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return 0
+	}
+	_, exp := math.Frexp(x)
+	return max(exp-1, 0)
+}
+
+// engine runs the operations with the arithmetic of one numeric mode, each
+// method parses the cells it reads and formats the numbers it writes
+type engine interface {
+	sum(ctx context.Context, reader *Reader) (string, error)
+	product(ctx context.Context, reader *Reader) (string, error)
+	sumSparse(ctx context.Context, m *mtxReader) (string, error)
+	productSparse(ctx context.Context, m *mtxReader) (string, error)
+	elementwise(ctx context.Context, a, b *Reader, w RowWriter, op elementOp) error
+	matmul(ctx context.Context, a *Reader, bt *os.File, bRows, bCols, bufferSize int, w RowWriter) error
+	determinant(ctx context.Context, reader *Reader) (string, error)
+	inverse(ctx context.Context, reader *Reader, w RowWriter) error
+	stats(ctx context.Context, reader *Reader) (*Stats, error)
+	checkCell(cell string) error
+}
+
+// numericEngine implements engine for the number type T
+type numericEngine[T any] struct {
+	ar      arithmetic[T]
+	workers int
+	limits  limits
+
+	// the int mode replaces the elimination over a field by exact algorithms
+	det    func(ctx context.Context, m [][]T) (T, error)
+	rank   func(ctx context.Context, m [][]T) (int, error)
+	invert func(ctx context.Context, m [][]T, w RowWriter) error
+}
+
+// newEngine returns the engine of the configured numeric mode
+func newEngine(cfg *config) engine {
+	nf := numberFormat{places: cfg.places, mode: cfg.rounding}
+	switch cfg.numeric {
+	case Rational:
+		return newFieldEngine[*big.Rat](ratArithmetic{nf}, cfg.concurrency, cfg.limits)
+	case Decimal:
+		return newFieldEngine[*big.Float](floatArithmetic{numberFormat: nf, prec: cfg.precision, rounding: cfg.rounding}, cfg.concurrency, cfg.limits)
+	case Float64:
+		return newFieldEngine[float64](float64Arithmetic{nf}, cfg.concurrency, cfg.limits)
+	default:
+		if cfg.modulus != nil {
+			return newModEngine(modArithmetic{numberFormat: nf, n: cfg.modulus}, cfg.concurrency)
+		}
+		ar := intArithmetic{nf}
+		return intEngine{&numericEngine[*big.Int]{
+			ar:      ar,
+			workers: cfg.concurrency,
+			limits:  cfg.limits,
+			det:     bareiss,
+			rank:    rankOf,
+			invert: func(ctx context.Context, m [][]*big.Int, w RowWriter) error {
+				// the inverse of an integer matrix has rational cells
+				rm := make([][]*big.Rat, len(m))
+				for i, row := range m {
+					rm[i] = make([]*big.Rat, len(row))
+					for j, x := range row {
+						rm[i][j] = new(big.Rat).SetInt(x)
+					}
+				}
+				return writeInverse(ctx, rm, ratArithmetic{nf}, w)
+			},
+		}}
+	}
+}
+
+// intEngine is the engine of the int mode, whose reductions run on native
+// integers until they overflow
+type intEngine struct {
+	*numericEngine[*big.Int]
+}
+
+func (e intEngine) sum(ctx context.Context, reader *Reader) (string, error) {
+	sum, err := sumInts(ctx, reader, e.workers)
+	if err != nil {
+		return "", err
+	}
+	return e.ar.format(sum), nil
+}
+
+func (e intEngine) product(ctx context.Context, reader *Reader) (string, error) {
+	product, err := productInts(ctx, reader, e.workers, e.limits)
+	if err != nil {
+		return "", err
+	}
+	return e.ar.format(product), nil
+}
+
+// newFieldEngine returns the engine of a mode with exact or rounded division
+func newFieldEngine[T any](ar arithmetic[T], workers int, l limits) *numericEngine[T] {
+	return &numericEngine[T]{
+		ar:      ar,
+		workers: workers,
+		limits:  l,
+		det: func(ctx context.Context, m [][]T) (T, error) {
+			return eliminationDeterminant(ctx, m, ar)
+		},
+		rank: func(ctx context.Context, m [][]T) (int, error) {
+			return eliminationRank(ctx, m, ar)
+		},
+		invert: func(ctx context.Context, m [][]T, w RowWriter) error {
+			return writeInverse(ctx, m, ar, w)
+		},
+	}
+}
+
+func (e *numericEngine[T]) sum(ctx context.Context, reader *Reader) (string, error) {
+	sum, err := sumOf(ctx, reader, e.ar, e.workers)
+	if err != nil {
+		return "", err
+	}
+	return e.ar.format(sum), nil
+}
+
+func (e *numericEngine[T]) product(ctx context.Context, reader *Reader) (string, error) {
+	product, err := productOf(ctx, reader, e.ar, e.workers, e.limits)
+	if err != nil {
+		return "", err
+	}
+	return e.ar.format(product), nil
+}
+
+func (e *numericEngine[T]) sumSparse(ctx context.Context, m *mtxReader) (string, error) {
+	sum, err := sumEntries(ctx, m, e.ar)
+	if err != nil {
+		return "", err
+	}
+	return e.ar.format(sum), nil
+}
+
+func (e *numericEngine[T]) productSparse(ctx context.Context, m *mtxReader) (string, error) {
+	product, err := productEntries(ctx, m, e.ar, e.limits)
+	if err != nil {
+		return "", err
+	}
+	return e.ar.format(product), nil
+}
+
+func (e *numericEngine[T]) elementwise(ctx context.Context, a, b *Reader, w RowWriter, op elementOp) error {
+	return elementwiseOf(ctx, a, b, w, e.ar, op)
+}
+
+func (e *numericEngine[T]) matmul(ctx context.Context, a *Reader, bt *os.File, bRows, bCols, bufferSize int, w RowWriter) error {
+	return matmulOf(ctx, a, bt, bRows, bCols, bufferSize, w, e.ar)
+}
+
+func (e *numericEngine[T]) determinant(ctx context.Context, reader *Reader) (string, error) {
+	m, err := readMatrix(ctx, reader, e.ar)
+	if err != nil {
+		return "", err
+	}
+	det, err := e.det(ctx, m)
+	if err != nil {
+		return "", err
+	}
+	return e.ar.format(det), nil
+}
+
+func (e *numericEngine[T]) inverse(ctx context.Context, reader *Reader, w RowWriter) error {
+	m, err := readMatrix(ctx, reader, e.ar)
+	if err != nil {
+		return err
+	}
+	return e.invert(ctx, m, w)
+}
+
+func (e *numericEngine[T]) stats(ctx context.Context, reader *Reader) (*Stats, error) {
+	return statsOf(ctx, reader, e.ar, e.rank)
+}
+
+func (e *numericEngine[T]) checkCell(cell string) error {
+	_, err := e.ar.parse(e.ar.zero(), cell)
+	return err
+}
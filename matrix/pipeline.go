@@ -0,0 +1,131 @@
+package matrix
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+)
+
+// no of rows handed to a worker at once by the reductions
+const chunkRows = 256
+
+// errSkipRest is returned by a pipeline merge function once the result is
+// known, the remaining chunks are neither read nor processed
+var errSkipRest = errors.New("skip the remaining chunks")
+
+// chunkResult is the outcome of the work on one chunk of rows
+type chunkResult[T any] struct {
+	value T
+	err   error
+}
+
+// pipeline reads the rows of reader in chunks of size rows on its own
+// goroutine, runs work on every chunk with the given number of worker
+// goroutines, and hands the results to merge in input order. work gets the
+// number of rows before the chunk, to locate the errors it reports. Errors are
+// reported in input order too, so the first invalid row wins as when reading
+// sequentially.
+//
+// At most workers chunks are in flight between the reader and merge, which
+// bounds the memory to workers+2 chunks.
+func pipeline[T any](ctx context.Context, reader *Reader, size, workers int, work func(first int, rows [][]string) (T, error), merge func(T) error) error {
+	workers = max(workers, 1)
+
+	var wg sync.WaitGroup
+	defer wg.Wait() // the reader must not outlive the caller's source
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type job struct {
+		first  int
+		rows   [][]string
+		result chan chunkResult[T]
+	}
+	jobs := make(chan job)
+	order := make(chan chan chunkResult[T], workers)
+
+	// split the input in chunks, queueing the result of each one in order
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(order)
+		defer close(jobs)
+		for {
+			rows, err := readChunk(ctx, reader, size)
+			if len(rows) > 0 {
+				result := make(chan chunkResult[T], 1)
+				select {
+				case order <- result:
+				case <-ctx.Done():
+					return
+				}
+				select {
+				case jobs <- job{first: reader.Rows() - len(rows), rows: rows, result: result}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					result := make(chan chunkResult[T], 1)
+					result <- chunkResult[T]{err: err}
+					select {
+					case order <- result:
+					case <-ctx.Done():
+					}
+				}
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				value, err := work(j.first, j.rows)
+				j.result <- chunkResult[T]{value: value, err: err}
+			}
+		}()
+	}
+
+	for result := range order {
+		var r chunkResult[T]
+		select {
+		case r = <-result:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if r.err != nil {
+			return r.err
+		}
+		if err := merge(r.value); err != nil {
+			if errors.Is(err, errSkipRest) {
+				return nil
+			}
+			return err
+		}
+	}
+	// the reader also stops when the caller's context is done
+	return ctx.Err()
+}
+
+// readChunk reads up to size rows, copied out of the reader's buffer. The rows
+// read before an error are returned with it.
+func readChunk(ctx context.Context, reader *Reader, size int) ([][]string, error) {
+	rows := make([][]string, 0, min(size, flushRows))
+	for len(rows) < size {
+		if err := ctx.Err(); err != nil {
+			return rows, err
+		}
+		record, err := reader.Read()
+		if err != nil {
+			return rows, err
+		}
+		rows = append(rows, slices.Clone(record))
+	}
+	return rows, nil
+}
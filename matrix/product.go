@@ -0,0 +1,156 @@
+package matrix
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// no of rows of A multiplied during each pass over the transposed B
+const productBlockRows = 64
+
+// BinaryOperation is the signature shared by the operations combining two
+// matrices.
+type BinaryOperation func(ctx context.Context, a, b io.Reader, dst io.Writer, opts ...Option) error
+
+// MatMul writes the matrix product A × B of the matrices read from a and b to
+// dst. The number of columns of A must match the number of rows of B.
+//
+// B is transposed into a temporary file first, so that each of its columns
+// can be streamed from disk instead of holding B in memory. A is then read in
+// blocks of rows and every block costs one pass over the transposed B.
+func MatMul(ctx context.Context, a, b io.Reader, dst io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+
+	tmpDir, err := os.MkdirTemp(cfg.tempDir, "matrix_product")
+	if err != nil {
+		return fmt.Errorf("fail to create directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// spill B column by column, each line of bt is a column of B
+	bt, err := os.CreateTemp(tmpDir, "product_bt_*.tmp")
+	if err != nil {
+		return fmt.Errorf("fail to create temp file: %w", err)
+	}
+	defer bt.Close()
+
+	bReader := newReader(ctx, b, cfg)
+	btWriter := newRowWriter(bt, CSV, cfg.writeBuffer)
+	if err = transpose(ctx, bReader, btWriter, tmpDir, cfg); err != nil {
+		return err
+	}
+	if err = btWriter.Close(); err != nil {
+		return err
+	}
+	bRows, bCols := bReader.Rows(), bReader.Cols()
+
+	cfg.enter(PhaseMultiplying)
+	w := cfg.resultWriter(dst)
+	aReader := newReader(ctx, a, cfg)
+	aReader.holdRows(productBlockRows, cfg.memoryBudget)
+	if err = newEngine(cfg).matmul(ctx, aReader, bt, bRows, bCols, cfg.readBuffer, w); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// matmulOf reads A in blocks of rows and multiplies every block by B, read
+// from the transposed file bt through a buffer of bufferSize
+func matmulOf[T any](ctx context.Context, aReader *Reader, bt *os.File, bRows, bCols, bufferSize int, w RowWriter, ar arithmetic[T]) error {
+	block := make([][]T, 0, productBlockRows)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record, err := aReader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) != bRows {
+			return newInputError(ErrDimensionMismatch, "inner dimensions mismatch: a has %d columns, b has %d rows", len(record), bRows)
+		}
+
+		row := make([]T, len(record))
+		for i, num := range record {
+			if row[i], err = ar.parse(ar.zero(), num); err != nil {
+				return locate(err, aReader.Rows(), i+1)
+			}
+		}
+		block = append(block, row)
+		if len(block) == productBlockRows {
+			if err = multiplyBlock(ctx, block, bt, bCols, bufferSize, w, ar); err != nil {
+				return err
+			}
+			block = block[:0]
+		}
+	}
+	// with no row in A, the empty block still parses every cell of B
+	if len(block) > 0 || aReader.Rows() == 0 {
+		return multiplyBlock(ctx, block, bt, bCols, bufferSize, w, ar)
+	}
+	return nil
+}
+
+// multiplyBlock multiplies a block of rows of A by B, reading the columns of B
+// from the transposed file bt, and writes the resulting rows to w
+func multiplyBlock[T any](ctx context.Context, block [][]T, bt *os.File, bCols, bufferSize int, w RowWriter, ar arithmetic[T]) error {
+	if _, err := bt.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	btReader := csv.NewReader(bufio.NewReaderSize(bt, bufferSize))
+	btReader.ReuseRecord = true
+
+	result := make([][]string, len(block))
+	for i := range result {
+		result[i] = make([]string, 0, bCols)
+	}
+
+	column := make([]T, 0)
+	dot, tmp := ar.zero(), ar.zero()
+	for j := 0; ; j++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record, err := btReader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// parse the column of B once for the whole block
+		for len(column) < len(record) {
+			column = append(column, ar.zero())
+		}
+		for k, num := range record {
+			if column[k], err = ar.parse(column[k], num); err != nil {
+				return locate(err, k+1, j+1) // row k of column j of B
+			}
+		}
+
+		for i, row := range block {
+			dot = ar.setInt64(dot, 0)
+			for k, x := range row {
+				tmp = ar.mul(tmp, x, column[k])
+				dot = ar.add(dot, dot, tmp)
+			}
+			result[i] = append(result[i], ar.format(dot))
+		}
+	}
+
+	for _, row := range result {
+		if err := w.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,29 @@
+package matrix
+
+import (
+	"io"
+	"math/rand"
+	"strconv"
+)
+
+// WriteRandom writes a rows × cols CSV matrix of random non zero integers
+// between -100 and 100, the kind of input used to load test the operations.
+func WriteRandom(w io.Writer, rows, cols int, rng *rand.Rand) error {
+	rw := NewRowWriter(w, CSV)
+	row := make([]string, cols)
+	for i := 0; i < rows; i++ {
+		for j := range row {
+			// generate random number between -100 and 100
+			// if the num is 0, redo
+			num := 0
+			for num == 0 {
+				num = rng.Intn(201) - 100
+			}
+			row[j] = strconv.Itoa(num)
+		}
+		if err := rw.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return rw.Close()
+}
@@ -0,0 +1,210 @@
+package matrix
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Input tags a source with the format it is encoded in. Passing an Input to
+// an operation or to NewReader takes precedence over WithInputFormat, which
+// lets the two matrices of a BinaryOperation come in different formats.
+type Input struct {
+	io.Reader
+	Format Format
+}
+
+// rowSource decodes the rows of one input format
+type rowSource interface {
+	Read() ([]string, error)
+}
+
+// Reader streams the rows of a matrix and checks that every row has as many
+// columns as the first one.
+type Reader struct {
+	// RequireSquare makes Read report ErrNotSquare at the end of the input
+	// when the number of rows differs from the number of columns.
+	RequireSquare bool
+
+	format Format
+	src    rowSource
+	rows   int
+	cols   int
+	peeked []string // row returned by peek, handed out by the next Read
+
+	progress func(rows int)
+	reported int // rows already passed to progress
+	limits   limits
+}
+
+// no of rows between two calls of the progress function
+const progressRows = 1000
+
+// NewReader returns a Reader reading rows from src through a buffer. The rows
+// are decoded as CSV unless another format is set with WithInputFormat or by
+// passing an Input.
+func NewReader(src io.Reader, opts ...Option) *Reader {
+	return newReader(context.Background(), src, newConfig(opts))
+}
+
+// resolveInput unwraps an Input, or else tags src with the configured format
+func resolveInput(src io.Reader, cfg *config) (io.Reader, Format) {
+	switch in := src.(type) {
+	case Input:
+		return in.Reader, in.Format
+	case *Input:
+		return in.Reader, in.Format
+	}
+	return src, cfg.inputFormat
+}
+
+// newReader returns the Reader of an operation, ctx stops the reading of the
+// entries of a MatrixMarket file
+func newReader(ctx context.Context, src io.Reader, cfg *config) *Reader {
+	src, format := resolveInput(src, cfg)
+	buffered := bufio.NewReaderSize(src, cfg.readBuffer)
+
+	r := &Reader{format: format, progress: cfg.progress, limits: cfg.limits}
+	switch format {
+	case TSV:
+		r.src = newCSVSource(buffered, '\t')
+	case JSON, NDJSON:
+		r.src = newJSONSource(buffered, format == NDJSON)
+	case Text:
+		r.src = &textSource{r: buffered}
+	case MatrixMarket:
+		r.src = &mtxRowSource{ctx: ctx, r: buffered, limits: cfg.limits}
+	default:
+		r.format = CSV
+		r.src = newCSVSource(buffered, ',')
+	}
+	return r
+}
+
+// holdRows tells that the operation holds rows rows of the matrix in memory
+// at once, all of them when 0. The rows a MatrixMarket file would densify are
+// then checked against the memory budget before its entries are read, the
+// other formats hold no more than the upload.
+func (r *Reader) holdRows(rows int, budget int64) {
+	if s, ok := r.src.(*mtxRowSource); ok {
+		s.heldRows, s.budget = rows, budget
+	}
+}
+
+// initialize csv parser, also used for TSV
+func newCSVSource(r io.Reader, comma rune) *csv.Reader {
+	csvReader := csv.NewReader(r)
+	csvReader.ReuseRecord = true
+	csvReader.Comma = comma
+	csvReader.LazyQuotes = comma == '\t' // TSV exports rarely quote their cells
+	csvReader.FieldsPerRecord = -1       // column count is checked by Read to report a helpful error
+	return csvReader
+}
+
+// Read returns the next row, or io.EOF once the input is exhausted and valid.
+// The returned slice is reused by the next call, copy it to keep it.
+func (r *Reader) Read() ([]string, error) {
+	if record := r.peeked; record != nil {
+		r.peeked = nil
+		return record, nil
+	}
+
+	record, err := r.src.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			r.reportProgress()
+			// check if the input is a matrix
+			if r.RequireSquare && r.rows != r.cols {
+				return nil, newInputError(ErrNotSquare, "Not a matrix: line: %d, columns: %d", r.rows, r.cols)
+			}
+			return nil, io.EOF
+		}
+		if IsInputError(err) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+		syntaxErr := newInputError(ErrSyntax, "%s parsing error: %v", strings.ToUpper(string(r.format)), err)
+		syntaxErr.Row = r.rows + 1
+		syntaxErr.cause = err
+		return nil, syntaxErr
+	}
+
+	if err := r.limits.checkRow(record, r.rows+1); err != nil {
+		return nil, err
+	}
+	// determine the expected column number by first row's columns
+	if r.rows == 0 {
+		r.cols = len(record)
+	}
+	if len(record) != r.cols {
+		return nil, raggedRow(record, r.rows+1, r.cols)
+	}
+	r.rows++
+	if r.rows-r.reported >= progressRows {
+		r.reportProgress()
+	}
+	return record, nil
+}
+
+// reportProgress passes the rows read since the previous call to progress
+func (r *Reader) reportProgress() {
+	if r.progress != nil && r.rows > r.reported {
+		r.progress(r.rows - r.reported)
+		r.reported = r.rows
+	}
+}
+
+// raggedRow reports the row-th record, which does not have cols cells. It is
+// located at the first cell in excess, or at the first one missing.
+func raggedRow(record []string, row, cols int) *Error {
+	err := newInputError(ErrRaggedRow, "column number inconsistent: row: %d expects %d colums", row, cols)
+	err.Row, err.Col = row, min(len(record), cols)+1
+	if len(record) > cols {
+		err.Token = record[cols]
+	}
+	return err
+}
+
+// peek returns the next row without consuming it, it is counted by Rows already
+func (r *Reader) peek() ([]string, error) {
+	if r.peeked == nil {
+		record, err := r.Read()
+		if err != nil {
+			return nil, err
+		}
+		r.peeked = record
+	}
+	return r.peeked, nil
+}
+
+// Rows returns the number of rows read so far.
+func (r *Reader) Rows() int {
+	return r.rows
+}
+
+// Cols returns the number of columns, known once the first row is read.
+func (r *Reader) Cols() int {
+	return r.cols
+}
+
+// textSource reads cells separated by any run of spaces or tabs, one row per
+// line. Blank lines and lines starting with % or # are skipped.
+type textSource struct {
+	r *bufio.Reader
+}
+
+func (t *textSource) Read() ([]string, error) {
+	for {
+		line, err := t.r.ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '%' || line[0] == '#' {
+			continue
+		}
+		return strings.Fields(line), nil
+	}
+}
@@ -0,0 +1,186 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"strconv"
+)
+
+// Stats holds the structural properties of a matrix.
+type Stats struct {
+	Rows int `json:"rows"`
+	Cols int `json:"columns"`
+	// Trace is the sum of the diagonal, empty unless the matrix is square.
+	Trace     Number `json:"trace,omitempty"`
+	Rank      int    `json:"rank"`
+	Nullity   int    `json:"nullity"`
+	Symmetric bool   `json:"symmetric"`
+}
+
+// Number is a number formatted in the numeric mode of an operation. It is
+// encoded in JSON as a number, or as a string when it is a fraction.
+type Number string
+
+func (n Number) MarshalJSON() ([]byte, error) {
+	if isJSONNumber(string(n)) {
+		return []byte(n), nil
+	}
+	return json.Marshal(string(n))
+}
+
+// Records returns the statistics as key/value rows.
+func (s *Stats) Records() [][]string {
+	records := [][]string{
+		{"rows", strconv.Itoa(s.Rows)},
+		{"columns", strconv.Itoa(s.Cols)},
+	}
+	if s.Trace != "" {
+		records = append(records, []string{"trace", string(s.Trace)})
+	}
+	return append(records,
+		[]string{"rank", strconv.Itoa(s.Rank)},
+		[]string{"nullity", strconv.Itoa(s.Nullity)},
+		[]string{"symmetric", strconv.FormatBool(s.Symmetric)},
+	)
+}
+
+// ComputeStats reads the matrix from src and computes its statistics. The
+// trace and the symmetry are found while streaming the rows, then the rank is
+// computed by elimination, exact unless the Decimal or Float64 numeric mode
+// is set.
+func ComputeStats(ctx context.Context, src io.Reader, opts ...Option) (*Stats, error) {
+	cfg := newConfig(opts)
+	reader := newReader(ctx, src, cfg)
+	reader.holdRows(0, cfg.memoryBudget)
+	return newEngine(cfg).stats(ctx, reader)
+}
+
+// statsOf computes the statistics of the matrix of reader, rank computes the
+// rank of the matrix once it is loaded
+func statsOf[T any](ctx context.Context, reader *Reader, ar arithmetic[T], rank func(context.Context, [][]T) (int, error)) (*Stats, error) {
+	var m [][]T
+	trace := ar.zero()
+	symmetric := true
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		i := len(m)
+		row := make([]T, len(record))
+		for j, num := range record {
+			if row[j], err = ar.parse(ar.zero(), num); err != nil {
+				return nil, locate(err, reader.Rows(), j+1)
+			}
+			if j == i {
+				trace = ar.add(trace, trace, row[j])
+			}
+			// compare the lower triangle with the rows already read
+			if j < i && i < len(record) && symmetric && ar.cmp(row[j], m[j][i]) != 0 {
+				symmetric = false
+			}
+		}
+		m = append(m, row)
+	}
+
+	stats := &Stats{Rows: reader.Rows(), Cols: reader.Cols()}
+	if stats.Rows == stats.Cols {
+		stats.Trace = Number(ar.format(trace))
+		stats.Symmetric = symmetric
+	}
+
+	r, err := rank(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	stats.Rank = r
+	stats.Nullity = stats.Cols - r
+	return stats, nil
+}
+
+// eliminationRank computes the rank of m by reducing it to a row echelon form
+// with partial pivoting, pivots within the tolerance of zero being skipped. m
+// is overwritten.
+func eliminationRank[T any](ctx context.Context, m [][]T, ar arithmetic[T]) (int, error) {
+	if len(m) == 0 {
+		return 0, nil
+	}
+	rows, cols := len(m), len(m[0])
+
+	tol := tolerance(m, ar)
+	rank := 0
+	factor, tmp := ar.zero(), ar.zero()
+	for col := 0; col < cols && rank < rows; col++ {
+		pivot := pivotRow(m, col, rank, ar)
+		if tmp = ar.abs(tmp, m[pivot][col]); ar.sign(tmp) == 0 || ar.cmp(tmp, tol) <= 0 {
+			continue // no pivot in this column
+		}
+		m[rank], m[pivot] = m[pivot], m[rank]
+
+		for i := rank + 1; i < rows; i++ {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+			if ar.sign(m[i][col]) == 0 {
+				continue
+			}
+			factor = ar.quo(factor, m[i][col], m[rank][col])
+			for j := col + 1; j < cols; j++ {
+				tmp = ar.mul(tmp, factor, m[rank][j])
+				m[i][j] = ar.sub(m[i][j], m[i][j], tmp)
+			}
+			m[i][col] = ar.setInt64(m[i][col], 0)
+		}
+		rank++
+	}
+	return rank, nil
+}
+
+// rankOf computes the rank of m by reducing it to a row echelon form with the
+// fraction free elimination, so every division stays exact. m is overwritten.
+func rankOf(ctx context.Context, m [][]*big.Int) (int, error) {
+	if len(m) == 0 {
+		return 0, nil
+	}
+	rows, cols := len(m), len(m[0])
+
+	rank := 0
+	prev := big.NewInt(1)
+	t1, t2 := new(big.Int), new(big.Int)
+	for col := 0; col < cols && rank < rows; col++ {
+		pivot := rank
+		for pivot < rows && m[pivot][col].Sign() == 0 {
+			pivot++
+		}
+		if pivot == rows {
+			continue // no pivot in this column
+		}
+		m[rank], m[pivot] = m[pivot], m[rank]
+
+		for i := rank + 1; i < rows; i++ {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+			for j := col + 1; j < cols; j++ {
+				// m[i][j] = (m[i][j] * pivot - m[i][col] * m[rank][j]) / prev
+				t1.Mul(m[i][j], m[rank][col])
+				t2.Mul(m[i][col], m[rank][j])
+				m[i][j].Quo(t1.Sub(t1, t2), prev)
+			}
+			m[i][col].SetInt64(0)
+		}
+		prev = m[rank][col]
+		rank++
+	}
+	return rank, nil
+}
@@ -0,0 +1,422 @@
+package matrix
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	maxSpillFiles   = 16          // upper bound of spill files, whatever the width of the matrix
+	minSpillCols    = 512         // columns below which another spill file is not worth opening
+	minSpillBuffer  = 4 * 1024    // 4KB, smallest read or write buffer of a spill file
+	maxSpillBuffer  = 1024 * 1024 // 1MB, largest read or write buffer of a spill file
+	cellMemOverhead = 16          // string header kept in memory for every buffered cell
+)
+
+// TransposePlan is the layout of an out-of-core transpose. Rows are buffered
+// in tiles of TileRows rows, and each tile is spilled column by column into
+// SpillFiles files, each file holding an even, contiguous range of columns so
+// that merging a range back into output rows only reads its own file.
+type TransposePlan struct {
+	Cols       int
+	TileRows   int
+	SpillFiles int
+	// MergeBuffer is the memory, in bytes, shared by the read buffers of the
+	// tiles of one file while they are merged, and by the write buffers of
+	// the files while tiles are spilled.
+	MergeBuffer int64
+	// MergeTiles is the most tiles merged side by side within MergeBuffer.
+	// When more are spilled, groups of MergeTiles tiles are first merged into
+	// larger tiles, in as many passes as needed.
+	MergeTiles int
+}
+
+// PlanTranspose sizes the transpose of a matrix of cols columns whose rows
+// hold about rowBytes bytes of cells, by workers goroutines. Half of the
+// memory budget holds the tiles in flight, one per worker plus the tile being
+// read and the tile being written, the other half is left to the spill
+// buffers.
+func PlanTranspose(cols, rowBytes int, budget int64, workers int) TransposePlan {
+	if budget <= 0 {
+		budget = defaultMemoryBudget
+	}
+	// a tile is held as strings, then encoded before it is written
+	rowMem := 2*int64(rowBytes) + int64(cols)*(cellMemOverhead+1)
+	tiles := int64(max(workers, 1) + 2)
+	return TransposePlan{
+		Cols:        cols,
+		TileRows:    int(max(budget/2/tiles/max(rowMem, 1), 1)),
+		SpillFiles:  min(max(cols/minSpillCols, 1), maxSpillFiles),
+		MergeBuffer: budget / 2,
+		// a merge pass reads MergeTiles tiles and writes one
+		MergeTiles: int(max(budget/2/minSpillBuffer-1, 2)),
+	}
+}
+
+// spill buffer size when n buffers share the merge memory
+func (p TransposePlan) spillBuffer(n int) int {
+	return int(min(max(p.MergeBuffer/int64(max(n, 1)), minSpillBuffer), maxSpillBuffer))
+}
+
+// TempFileHelper spills tiles of rows into temporary files and merges them
+// back as the rows of the transposed matrix.
+//
+// A spill file is a sequence of tiles. A tile stores the cells of each of the
+// file's columns in turn, every cell prefixed by its length as a uvarint.
+type TempFileHelper struct {
+	plan     TransposePlan
+	dir      string
+	colRange [][2]int // columns held by each file
+	files    []*os.File
+	writers  []*bufio.Writer
+	sizes    []int64   // bytes written to each file
+	offsets  [][]int64 // start of every tile in each file
+	tileRows []int     // rows of every tile
+	spilled  func(bytes int)
+}
+
+// NewTempFileHelper creates the spill files of plan under tempDir.
+func NewTempFileHelper(tempDir string, plan TransposePlan) (*TempFileHelper, error) {
+	files := max(min(plan.SpillFiles, plan.Cols), 1)
+	plan.MergeTiles = max(plan.MergeTiles, 2) // a pass merges at least two tiles
+	th := &TempFileHelper{
+		plan:    plan,
+		dir:     tempDir,
+		sizes:   make([]int64, files),
+		offsets: make([][]int64, files),
+	}
+
+	// spread the columns evenly, the first files take one extra column
+	baseCols, extraCols := plan.Cols/files, plan.Cols%files
+	currentCol := 0
+	for i := 0; i < files; i++ {
+		cols := baseCols
+		if i < extraCols {
+			cols++
+		}
+		th.colRange = append(th.colRange, [2]int{currentCol, currentCol + cols})
+		currentCol += cols
+	}
+
+	bufferSize := plan.spillBuffer(files)
+	for i := 0; i < files; i++ {
+		file, err := os.CreateTemp(tempDir, fmt.Sprintf("invert_%d_*.tmp", i))
+		if err != nil {
+			th.Close()
+			return nil, err
+		}
+		th.files = append(th.files, file)
+		th.writers = append(th.writers, bufio.NewWriterSize(file, bufferSize))
+	}
+	return th, nil
+}
+
+// ProcessBlock spills a tile of at most TileRows rows, each file receiving the
+// columns of its range.
+func (th *TempFileHelper) ProcessBlock(block [][]string) error {
+	return th.writeTile(th.encodeTile(block))
+}
+
+// encodeTile encodes the columns of each file for a tile of rows. It only
+// reads the helper, so tiles can be encoded concurrently.
+func (th *TempFileHelper) encodeTile(block [][]string) *encodedTile {
+	tile := &encodedTile{rows: len(block), files: make([][]byte, len(th.colRange))}
+	if len(block) == 0 {
+		return tile
+	}
+	for fileIdx, cols := range th.colRange {
+		size := 0
+		for _, row := range block {
+			for _, cell := range row[cols[0]:cols[1]] {
+				size += len(cell) + 1
+			}
+		}
+		buf := make([]byte, 0, size)
+		for col := cols[0]; col < cols[1]; col++ {
+			for _, row := range block {
+				buf = binary.AppendUvarint(buf, uint64(len(row[col])))
+				buf = append(buf, row[col]...)
+			}
+		}
+		tile.files[fileIdx] = buf
+	}
+	return tile
+}
+
+// encodedTile holds the content written to each file for a tile
+type encodedTile struct {
+	rows  int
+	files [][]byte
+}
+
+// writeTile appends an encoded tile to the files, tiles must be written in
+// the order of their rows
+func (th *TempFileHelper) writeTile(tile *encodedTile) error {
+	if tile.rows == 0 {
+		return nil
+	}
+	written := 0
+	for fileIdx, buf := range tile.files {
+		th.offsets[fileIdx] = append(th.offsets[fileIdx], th.sizes[fileIdx])
+		if _, err := th.writers[fileIdx].Write(buf); err != nil {
+			return err
+		}
+		th.sizes[fileIdx] += int64(len(buf))
+		written += len(buf)
+	}
+	th.tileRows = append(th.tileRows, tile.rows)
+	if th.spilled != nil {
+		th.spilled(written)
+	}
+	return nil
+}
+
+// StreamOutput merges the tiles into the rows of the transposed matrix, the
+// caller closes w. Each file is merged in one pass that reads all of its tiles
+// side by side, once there are no more than MergeTiles of them, so memory is
+// bounded by the merge buffer whatever the size of the matrix.
+func (th *TempFileHelper) StreamOutput(ctx context.Context, w RowWriter) error {
+	for _, writer := range th.writers {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+	}
+	for len(th.tileRows) > th.plan.MergeTiles {
+		if err := th.mergeTiles(ctx); err != nil {
+			return err
+		}
+	}
+
+	bufferSize := th.plan.spillBuffer(len(th.tileRows))
+	for fileIdx, cols := range th.colRange {
+		cursors := th.cursors(th.files[fileIdx], fileIdx, 0, len(th.tileRows), bufferSize)
+		for col := cols[0]; col < cols[1]; col++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			for _, cursor := range cursors {
+				cells, err := cursor.next()
+				if err != nil {
+					return fmt.Errorf("fail to read temp file: %w", err)
+				}
+				if err = w.WriteCells(cells); err != nil {
+					return err
+				}
+			}
+			if err := w.EndRow(); err != nil {
+				return err
+			}
+			if (col+1)%flushRows == 0 {
+				if err := w.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// cursors returns the cursors of the tiles from to to of the spill file of
+// fileIdx, each reading the tile's section of file
+func (th *TempFileHelper) cursors(file *os.File, fileIdx, from, to, bufferSize int) []*tileCursor {
+	cursors := make([]*tileCursor, 0, to-from)
+	for t := from; t < to; t++ {
+		start, end := th.offsets[fileIdx][t], th.sizes[fileIdx]
+		if t+1 < len(th.tileRows) {
+			end = th.offsets[fileIdx][t+1]
+		}
+		section := io.NewSectionReader(file, start, end-start)
+		cursors = append(cursors, &tileCursor{r: bufio.NewReaderSize(section, bufferSize), cells: make([]string, th.tileRows[t])})
+	}
+	return cursors
+}
+
+// mergeTiles merges every group of MergeTiles consecutive tiles into one
+// tile, rewriting each spill file into a new one
+func (th *TempFileHelper) mergeTiles(ctx context.Context) error {
+	var tileRows []int
+	for from := 0; from < len(th.tileRows); from += th.plan.MergeTiles {
+		rows := 0
+		for _, n := range th.tileRows[from:min(from+th.plan.MergeTiles, len(th.tileRows))] {
+			rows += n
+		}
+		tileRows = append(tileRows, rows)
+	}
+	for fileIdx := range th.colRange {
+		if err := th.mergeFile(ctx, fileIdx); err != nil {
+			return err
+		}
+	}
+	th.tileRows = tileRows
+	return nil
+}
+
+// mergeFile writes the merged tiles of a spill file into a new file, which
+// replaces it
+func (th *TempFileHelper) mergeFile(ctx context.Context, fileIdx int) error {
+	fanIn := th.plan.MergeTiles
+	bufferSize := th.plan.spillBuffer(fanIn + 1)
+	file, err := os.CreateTemp(th.dir, fmt.Sprintf("invert_%d_*.tmp", fileIdx))
+	if err != nil {
+		return err
+	}
+	// the new file is closed by Close from now on, whether the merge succeeds
+	// or not
+	old := th.files[fileIdx]
+	th.files[fileIdx] = file
+	defer func() {
+		old.Close()
+		os.Remove(old.Name())
+	}()
+
+	w := bufio.NewWriterSize(file, bufferSize)
+	cols := th.colRange[fileIdx]
+	offsets := make([]int64, 0, len(th.tileRows)/fanIn+1)
+	size := int64(0)
+	var buf []byte
+	for from := 0; from < len(th.tileRows); from += fanIn {
+		offsets = append(offsets, size)
+		cursors := th.cursors(old, fileIdx, from, min(from+fanIn, len(th.tileRows)), bufferSize)
+		for col := cols[0]; col < cols[1]; col++ {
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+			for _, cursor := range cursors {
+				cells, err := cursor.next()
+				if err != nil {
+					return fmt.Errorf("fail to read temp file: %w", err)
+				}
+				buf = buf[:0]
+				for _, cell := range cells {
+					buf = binary.AppendUvarint(buf, uint64(len(cell)))
+					buf = append(buf, cell...)
+				}
+				if _, err = w.Write(buf); err != nil {
+					return err
+				}
+				size += int64(len(buf))
+			}
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	th.writers[fileIdx] = w
+	th.offsets[fileIdx], th.sizes[fileIdx] = offsets, size
+	if th.spilled != nil {
+		th.spilled(int(size))
+	}
+	return nil
+}
+
+// tileCursor reads the cells of one column of a tile at a time
+type tileCursor struct {
+	r     *bufio.Reader
+	cells []string
+	buf   []byte
+}
+
+func (c *tileCursor) next() ([]string, error) {
+	for i := range c.cells {
+		n, err := binary.ReadUvarint(c.r)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(cap(c.buf)) < n {
+			c.buf = make([]byte, n)
+		}
+		if _, err = io.ReadFull(c.r, c.buf[:n]); err != nil {
+			return nil, err
+		}
+		c.cells[i] = string(c.buf[:n])
+	}
+	return c.cells, nil
+}
+
+// Transpose writes the matrix read from src to dst with its rows and columns
+// swapped. Tiles of rows are spilled into temporary files so the matrix never
+// has to fit in memory, see WithMemoryBudget.
+func Transpose(ctx context.Context, src io.Reader, dst io.Writer, opts ...Option) error {
+	cfg := newConfig(opts)
+	if m, ok, err := sparseInput(src, cfg, false); ok {
+		if err != nil {
+			return err
+		}
+		return copySparse(ctx, m, dst, cfg, true)
+	}
+
+	w := cfg.rowWriter(dst)
+	if err := transpose(ctx, newReader(ctx, src, cfg), w, cfg.tempDir, cfg); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// transpose streams the rows of reader to dst as columns, spilling tiles into
+// temporary files created under tempDir. Tiles are encoded by the workers of
+// a pipeline and written in order.
+func transpose(ctx context.Context, reader *Reader, dst RowWriter, tempDir string, cfg *config) error {
+	// get column number and row size from the first row
+	firstRow, err := reader.peek()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil // nothing to transpose
+		}
+		return err
+	}
+	totalCols := reader.Cols()
+	if totalCols == 0 {
+		return nil
+	}
+	rowBytes := 0
+	for _, cell := range firstRow {
+		rowBytes += len(cell)
+	}
+	plan := PlanTranspose(totalCols, rowBytes, cfg.memoryBudget, cfg.concurrency)
+
+	tmpDir, err := os.MkdirTemp(tempDir, "matrix_invert")
+	if err != nil {
+		return fmt.Errorf("fail to create directory: %w", err)
+	}
+
+	helper, err := NewTempFileHelper(tmpDir, plan)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return fmt.Errorf("fail to init temp file helper: %w", err)
+	}
+	defer func() {
+		helper.Close()
+		os.RemoveAll(tmpDir)
+	}()
+	helper.spilled = cfg.spill
+
+	// read tiles, encode them on the workers and write them into temp files
+	cfg.enter(PhaseTiling)
+	encode := func(_ int, block [][]string) (*encodedTile, error) {
+		return helper.encodeTile(block), nil
+	}
+	if err = pipeline(ctx, reader, plan.TileRows, cfg.concurrency, encode, helper.writeTile); err != nil {
+		return err
+	}
+	cfg.enter(PhaseMerging)
+	return helper.StreamOutput(ctx, dst)
+}
+
+// Close and remove the temp files
+func (th *TempFileHelper) Close() error {
+	var firstErr error
+	for _, file := range th.files {
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := os.Remove(file.Name()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
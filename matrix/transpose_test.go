@@ -0,0 +1,303 @@
+package matrix
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanTranspose(t *testing.T) {
+	tests := []struct {
+		name     string
+		cols     int
+		rowBytes int
+		budget   int64
+		workers  int
+		want     TransposePlan
+	}{
+		{name: "Narrow matrix fits a tile", cols: 3, rowBytes: 3, budget: 1 << 20, workers: 1, want: TransposePlan{Cols: 3, TileRows: 3066, SpillFiles: 1, MergeBuffer: 1 << 19, MergeTiles: 127}},
+		{name: "Wide matrix", cols: 50000, rowBytes: 450000, budget: 64 << 20, workers: 1, want: TransposePlan{Cols: 50000, TileRows: 6, SpillFiles: 16, MergeBuffer: 32 << 20, MergeTiles: 8191}},
+		{name: "Tiles shared by the workers", cols: 50000, rowBytes: 450000, budget: 64 << 20, workers: 8, want: TransposePlan{Cols: 50000, TileRows: 1, SpillFiles: 16, MergeBuffer: 32 << 20, MergeTiles: 8191}},
+		{name: "Row larger than the budget", cols: 2048, rowBytes: 20000, budget: 1024, workers: 1, want: TransposePlan{Cols: 2048, TileRows: 1, SpillFiles: 4, MergeBuffer: 512, MergeTiles: 2}},
+		{name: "Default budget", cols: 10, rowBytes: 10, workers: 4, want: TransposePlan{Cols: 10, TileRows: 29433, SpillFiles: 1, MergeBuffer: 32 << 20, MergeTiles: 8191}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := PlanTranspose(tt.cols, tt.rowBytes, tt.budget, tt.workers)
+			assert.Equal(t, tt.want, plan)
+			// the buffers of the tiles merged side by side, and of the file
+			// written by a merge pass, fit the merge buffer unless it is
+			// smaller than the two tiles of a pass
+			if plan.MergeTiles > 2 {
+				assert.LessOrEqual(t, int64(plan.MergeTiles+1)*int64(plan.spillBuffer(plan.MergeTiles+1)), plan.MergeBuffer)
+			}
+		})
+	}
+}
+
+func TestMergeTiles(t *testing.T) {
+	plan := TransposePlan{Cols: 3, TileRows: 1, SpillFiles: 1, MergeBuffer: 4 * minSpillBuffer, MergeTiles: 3}
+	th, err := NewTempFileHelper(t.TempDir(), plan)
+	assert.NoError(t, err)
+	defer th.Close()
+
+	// 20 tiles of one row are merged into 7, then 3 tiles
+	input, want := testMatrix(20, 3)
+	reader := NewReader(strings.NewReader(input))
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		assert.NoError(t, th.ProcessBlock([][]string{record}))
+	}
+	var out bytes.Buffer
+	w := NewRowWriter(&out, CSV)
+	assert.NoError(t, th.StreamOutput(context.Background(), w))
+	assert.NoError(t, w.Close())
+	assert.Equal(t, want, out.String())
+	assert.Equal(t, []int{9, 9, 2}, th.tileRows)
+}
+
+func TestTransposeMemoryBudget(t *testing.T) {
+	tests := []struct {
+		name   string
+		rows   int
+		cols   int
+		budget int64
+	}{
+		{name: "One row per tile", rows: 7, cols: 5, budget: 1},
+		{name: "Several tiles and spill files", rows: 40, cols: 1500, budget: 200 * 1024},
+		{name: "Single tile", rows: 40, cols: 30, budget: 0},
+		{name: "Single row", rows: 1, cols: 1100, budget: 1},
+		{name: "Merged in several passes", rows: 300, cols: 600, budget: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input, want := testMatrix(tt.rows, tt.cols)
+			var out bytes.Buffer
+			err := Transpose(context.Background(), strings.NewReader(input), &out, WithTempDir(t.TempDir()), WithMemoryBudget(tt.budget))
+			assert.NoError(t, err)
+			assert.Equal(t, want, out.String())
+		})
+	}
+
+	t.Run("Empty and quoted cells", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Transpose(context.Background(), strings.NewReader("1,,\"a,b\"\n,5,6"), &out, WithTempDir(t.TempDir()), WithMemoryBudget(1))
+		assert.NoError(t, err)
+		assert.Equal(t, "1,\n,5\n\"a,b\",6\n", out.String())
+	})
+
+	t.Run("Temp files removed", func(t *testing.T) {
+		dir := t.TempDir()
+		input, _ := testMatrix(10, 10)
+		err := Transpose(context.Background(), strings.NewReader(input), io.Discard, WithTempDir(dir), WithMemoryBudget(1))
+		assert.NoError(t, err)
+		entries, err := os.ReadDir(dir)
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
+
+// testMatrix returns a rows x cols matrix of distinct cells and its transpose,
+// both as CSV
+func testMatrix(rows, cols int) (string, string) {
+	var input, want strings.Builder
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			if j > 0 {
+				input.WriteByte(',')
+			}
+			fmt.Fprint(&input, i*cols+j)
+		}
+		input.WriteByte('\n')
+	}
+	for j := 0; j < cols; j++ {
+		for i := 0; i < rows; i++ {
+			if i > 0 {
+				want.WriteByte(',')
+			}
+			fmt.Fprint(&want, i*cols+j)
+		}
+		want.WriteByte('\n')
+	}
+	return input.String(), want.String()
+}
+
+func BenchmarkTranspose(b *testing.B) {
+	for _, n := range []int{100, 500} {
+		input, _ := testMatrix(n, n)
+		b.Run(fmt.Sprintf("%dx%d", n, n), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				err := Transpose(context.Background(), strings.NewReader(input), io.Discard, WithTempDir(b.TempDir()))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("%dx%d 256KB budget", n, n), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				err := Transpose(context.Background(), strings.NewReader(input), io.Discard, WithTempDir(b.TempDir()), WithMemoryBudget(256*1024))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("%dx%d legacy", n, n), func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				if err := legacyTranspose(strings.NewReader(input), io.Discard, b.TempDir()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// legacyTranspose is the transpose this package shipped with, spilling blocks
+// of 6 rows into 3 temp files and assembling each file's columns in memory.
+// It is kept as the baseline of BenchmarkTranspose.
+func legacyTranspose(src io.Reader, dst io.Writer, tempDir string) error {
+	const (
+		blockSize    = 6
+		tmpFileCount = 3
+		bufferSize   = 4 * 1024 * 1024
+	)
+
+	reader := NewReader(src)
+	firstRow, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	totalCols := reader.Cols()
+
+	// column range of each temp file, the last one may be short
+	cols := totalCols / tmpFileCount
+	if totalCols%tmpFileCount > 0 {
+		cols++
+	}
+	colRanges := make([][2]int, tmpFileCount)
+	for i := range colRanges {
+		colRanges[i] = [2]int{i * cols, (i + 1) * cols}
+	}
+	files := make([]*os.File, tmpFileCount)
+	writers := make([]*csv.Writer, tmpFileCount)
+	for i := range files {
+		if files[i], err = os.CreateTemp(tempDir, fmt.Sprintf("invert_%d_*.tmp", i)); err != nil {
+			return err
+		}
+		defer files[i].Close()
+		writers[i] = csv.NewWriter(bufio.NewWriterSize(files[i], bufferSize))
+	}
+
+	processBlock := func(block [][]string) error {
+		transposed := transposeInMemory(block)
+		for fileIdx := 0; fileIdx < tmpFileCount; fileIdx++ {
+			placeholderNum := 0
+			start, end := colRanges[fileIdx][0], colRanges[fileIdx][1]
+			if start >= len(transposed) {
+				break
+			}
+			if end > len(transposed) {
+				placeholderNum = end - len(transposed) + 1
+				end = len(transposed)
+			}
+			for _, col := range transposed[start:end] {
+				if err := writers[fileIdx].Write(col); err != nil {
+					return err
+				}
+			}
+			for i := 0; i < placeholderNum-1; i++ {
+				if err := writers[fileIdx].Write([]string{" "}); err != nil {
+					return err
+				}
+			}
+			writers[fileIdx].Flush()
+		}
+		return nil
+	}
+
+	block := [][]string{append([]string(nil), firstRow...)}
+	for {
+		record, rerr := reader.Read()
+		if errors.Is(rerr, io.EOF) {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+		block = append(block, append([]string(nil), record...))
+		if len(block) == blockSize {
+			if err = processBlock(block); err != nil {
+				return err
+			}
+			block = block[:0]
+		}
+	}
+	if len(block) > 0 {
+		if err = processBlock(block); err != nil {
+			return err
+		}
+	}
+
+	// assemble the rows of every file in memory
+	w := NewRowWriter(dst, CSV)
+	for _, file := range files {
+		if _, err = file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r := csv.NewReader(bufio.NewReader(file))
+		r.FieldsPerRecord = -1
+		rows := make([][]string, cols)
+		for colIdx := 0; ; colIdx++ {
+			record, rerr := r.Read()
+			if errors.Is(rerr, io.EOF) {
+				break
+			}
+			if rerr != nil {
+				return rerr
+			}
+			if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+				continue
+			}
+			rows[colIdx%cols] = append(rows[colIdx%cols], record...)
+		}
+		for _, row := range rows {
+			if len(row) == 0 {
+				continue
+			}
+			if err = w.WriteRow(row); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Close()
+}
+
+// transposeInMemory transposes a block of rows
+func transposeInMemory(matrix [][]string) [][]string {
+	if len(matrix) == 0 {
+		return nil
+	}
+	result := make([][]string, len(matrix[0]))
+	for j := range result {
+		result[j] = make([]string, len(matrix))
+		for i := range matrix {
+			result[j][i] = matrix[i][j]
+		}
+	}
+	return result
+}
@@ -0,0 +1,216 @@
+package matrix
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+)
+
+// byte order mark some editors write at the start of UTF-8 files
+const bom = "\ufeff"
+
+// Report lists the problems found in a matrix by Validate.
+type Report struct {
+	// Valid is true when no problem was found.
+	Valid bool `json:"valid"`
+	Rows  int  `json:"rows"`
+	Cols  int  `json:"columns"`
+	// Square is true when the matrix has as many rows as columns.
+	Square bool `json:"square"`
+	// Findings holds the first problems found, up to the cap set with
+	// WithMaxFindings, and Count the number of problems found in total.
+	Findings  []Finding `json:"findings"`
+	Count     int       `json:"count"`
+	Truncated bool      `json:"truncated"`
+}
+
+// Finding is one problem found by Validate. Row and Col are 1-based and 0
+// when the problem is not about one row or one cell, Line is the line of the
+// input when it is known.
+type Finding struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	Row     int    `json:"row,omitempty"`
+	Col     int    `json:"column,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Token   string `json:"token,omitempty"`
+}
+
+// WithMaxFindings caps the number of findings listed by Validate. The
+// default is 100.
+func WithMaxFindings(n int) Option {
+	return func(c *config) {
+		c.maxFindings = n
+	}
+}
+
+// Validate reads the whole matrix from src and reports every problem found
+// rather than stopping at the first one: ragged rows, cells that are not
+// numbers of the Numeric mode, syntax errors, and in CSV and TSV blank lines,
+// a byte order mark and trailing delimiters, which the other operations skip
+// or read as cells. The input is decoded with the same settings as the other
+// operations. The error is only set when the input cannot be read.
+func Validate(ctx context.Context, src io.Reader, opts ...Option) (*Report, error) {
+	cfg := newConfig(opts)
+	v := &validator{
+		report:    &Report{Findings: []Finding{}},
+		max:       cfg.maxFindings,
+		checkCell: newEngine(cfg).checkCell,
+		progress:  cfg.progress,
+		limits:    cfg.limits,
+	}
+
+	src, format := resolveInput(src, cfg)
+	buffered := bufio.NewReaderSize(src, cfg.readBuffer)
+	var err error
+	switch format {
+	case CSV, TSV:
+		comma := ','
+		if format == TSV {
+			comma = '\t'
+		}
+		err = v.scanDelimited(ctx, newCSVSource(buffered, comma), format)
+	default:
+		err = v.scanRows(ctx, newReader(ctx, Input{Reader: buffered, Format: format}, cfg).src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v.progress != nil && v.report.Rows%progressRows != 0 {
+		v.progress(v.report.Rows % progressRows)
+	}
+
+	r := v.report
+	r.Valid = r.Count == 0
+	r.Square = r.Rows > 0 && r.Rows == r.Cols
+	r.Truncated = r.Count > len(r.Findings)
+	return r, nil
+}
+
+// validator collects the findings of Validate
+type validator struct {
+	report    *Report
+	max       int
+	checkCell func(cell string) error
+	progress  func(rows int)
+	limits    limits
+}
+
+// add records a finding, counted but not listed once the cap is reached
+func (v *validator) add(f Finding) {
+	v.report.Count++
+	if len(v.report.Findings) < v.max {
+		v.report.Findings = append(v.report.Findings, f)
+	}
+}
+
+// addError records an Error of the operations as a finding
+func (v *validator) addError(err *Error, line int) {
+	v.add(Finding{Code: err.Code, Message: err.Error(), Row: err.Row, Col: err.Col, Line: line, Token: err.Token})
+}
+
+// scanDelimited validates CSV or TSV, csv.Reader skips the blank lines, they
+// are found from the gaps between the lines of the records
+func (v *validator) scanDelimited(ctx context.Context, cr *csv.Reader, format Format) error {
+	lastLine := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			// the reader goes on with the next record
+			syntaxErr := newInputError(ErrSyntax, "%s parsing error: %v", strings.ToUpper(string(format)), err)
+			syntaxErr.Row = v.report.Rows + 1
+			v.addError(syntaxErr, parseErr.StartLine)
+			lastLine = parseErr.Line
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		line, _ := cr.FieldPos(0)
+		for blank := lastLine + 1; blank < line; blank++ {
+			v.add(Finding{Code: CodeBlankLine, Message: "blank line", Line: blank})
+		}
+		lastLine, _ = cr.FieldPos(len(record) - 1)
+
+		row := v.report.Rows + 1
+		if row == 1 && strings.HasPrefix(record[0], bom) {
+			v.add(Finding{Code: CodeBOM, Message: "byte order mark at the start of the file", Row: 1, Col: 1, Line: line})
+			record[0] = strings.TrimPrefix(record[0], bom)
+		}
+		if n := len(record); n > 1 && record[n-1] == "" {
+			v.add(Finding{Code: CodeTrailingDelimiter, Message: "trailing delimiter", Row: row, Col: n, Line: line})
+			record = record[:n-1]
+		}
+		if err = v.checkRow(record, line); err != nil {
+			return err
+		}
+	}
+}
+
+// scanRows validates the rows of the other formats, whose decoders cannot go
+// on after a syntax error
+func (v *validator) scanRows(ctx context.Context, src rowSource) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record, err := src.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			var inputErr *Error
+			if !errors.As(err, &inputErr) {
+				inputErr = newInputError(ErrSyntax, "parsing error: %v", err)
+			}
+			if inputErr.Row == 0 {
+				inputErr.Row = v.report.Rows + 1
+			}
+			v.addError(inputErr, 0)
+			return nil
+		}
+		if err = v.checkRow(record, 0); err != nil {
+			return err
+		}
+	}
+}
+
+// checkRow checks the shape of a row against the first one, and its cells. A
+// row beyond the limits stops the validation with an error rather than a
+// finding.
+func (v *validator) checkRow(record []string, line int) error {
+	r := v.report
+	if err := v.limits.checkRow(record, r.Rows+1); err != nil {
+		return err
+	}
+	r.Rows++
+	if v.progress != nil && r.Rows%progressRows == 0 {
+		v.progress(progressRows)
+	}
+	if r.Rows == 1 {
+		r.Cols = len(record)
+	}
+	if len(record) != r.Cols {
+		v.addError(raggedRow(record, r.Rows, r.Cols), line)
+	}
+	for j, cell := range record {
+		if err := v.checkCell(cell); err != nil {
+			var inputErr *Error
+			if errors.As(locate(err, r.Rows, j+1), &inputErr) {
+				v.addError(inputErr, line)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/league/BackendChallenge/matrix"
+)
+
+// content type of the Prometheus text exposition format
+const mimeMetrics = "text/plain; version=0.0.4; charset=utf-8"
+
+// upper bounds in seconds of the buckets of the latency histograms, from a
+// small matrix answered from memory to one running up to maxProcessTime
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900}
+
+// metrics of the server, answered by GET /metrics
+var metrics = newServerMetrics()
+
+// routeStatus is the key of the request counters
+type routeStatus struct {
+	route  string
+	method string
+	code   int
+}
+
+// histogram counts observations in latencyBuckets, counts[i] are the ones
+// up to latencyBuckets[i] and the last the ones above all of them
+type histogram struct {
+	counts []uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(latencyBuckets, v)
+	h.counts[i]++
+	h.sum += v
+}
+
+// serverMetrics counts the requests and the work of the operations
+type serverMetrics struct {
+	mu        sync.Mutex
+	requests  map[routeStatus]uint64
+	latencies map[string]*histogram // by route
+	bytesIn   map[string]uint64     // by route
+	bytesOut  map[string]uint64     // by route
+	rows      map[string]uint64     // by operation
+	spilled   map[string]uint64     // by operation
+
+	inFlight atomic.Int64 // operations running, requests and jobs
+	timeouts atomic.Uint64
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests:  make(map[routeStatus]uint64),
+		latencies: make(map[string]*histogram),
+		bytesIn:   make(map[string]uint64),
+		bytesOut:  make(map[string]uint64),
+		rows:      make(map[string]uint64),
+		spilled:   make(map[string]uint64),
+	}
+}
+
+// request records a request answered with code after elapsed
+func (m *serverMetrics) request(key routeStatus, elapsed time.Duration, in, out int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[key]++
+	h, ok := m.latencies[key.route]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		m.latencies[key.route] = h
+	}
+	h.observe(elapsed.Seconds())
+	m.bytesIn[key.route] += uint64(in)
+	m.bytesOut[key.route] += uint64(max(out, 0))
+}
+
+// add adds n to the counter of operation in counters
+func (m *serverMetrics) add(counters map[string]uint64, operation string, n int) {
+	m.mu.Lock()
+	counters[operation] += uint64(n)
+	m.mu.Unlock()
+}
+
+// options count the rows read and the bytes spilled by operation, progress
+// is called as well when not nil
+func (m *serverMetrics) options(operation string, progress func(rows int)) []matrix.Option {
+	return []matrix.Option{
+		matrix.WithProgress(func(rows int) {
+			m.add(m.rows, operation, rows)
+			if progress != nil {
+				progress(rows)
+			}
+		}),
+		matrix.WithSpill(func(bytes int) { m.add(m.spilled, operation, bytes) }),
+	}
+}
+
+// recordMetrics counts the requests, their status code, latency and bytes.
+// The error of the handler is answered here so that its status is known.
+func recordMetrics(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		req := c.Request()
+		body := &countingBody{ReadCloser: req.Body}
+		req.Body = body
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+
+		route := c.Path()
+		if route == "" {
+			route = "unmatched" // keeps the unknown paths out of the labels
+		}
+		resp := c.Response()
+		metrics.request(routeStatus{route: route, method: req.Method, code: resp.Status}, time.Since(start), body.n, resp.Size)
+		return nil
+	}
+}
+
+// countingBody counts the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// Metrics answers the metrics in the Prometheus text exposition format
+func Metrics(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderContentType, mimeMetrics)
+	c.Response().WriteHeader(http.StatusOK)
+	_, err := io.WriteString(c.Response(), metrics.text())
+	return err
+}
+
+// text writes the metrics in the Prometheus text exposition format, the
+// series sorted by their labels
+func (m *serverMetrics) text() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+
+	header(&b, "matrix_http_requests_total", "counter", "Requests answered, by route, method and status code.")
+	keys := make([]routeStatus, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		x, y := keys[i], keys[j]
+		if x.route != y.route {
+			return x.route < y.route
+		}
+		if x.method != y.method {
+			return x.method < y.method
+		}
+		return x.code < y.code
+	})
+	for _, key := range keys {
+		sample(&b, "matrix_http_requests_total", labels("route", key.route, "method", key.method, "code", strconv.Itoa(key.code)), count(m.requests[key]))
+	}
+
+	header(&b, "matrix_http_request_duration_seconds", "histogram", "Time taken to answer the requests, by route.")
+	for _, route := range sortedKeys(m.latencies) {
+		h := m.latencies[route]
+		cumulative := uint64(0)
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			sample(&b, "matrix_http_request_duration_seconds_bucket", labels("route", route, "le", formatFloat(le)), count(cumulative))
+		}
+		cumulative += h.counts[len(latencyBuckets)]
+		sample(&b, "matrix_http_request_duration_seconds_bucket", labels("route", route, "le", "+Inf"), count(cumulative))
+		sample(&b, "matrix_http_request_duration_seconds_sum", labels("route", route), formatFloat(h.sum))
+		sample(&b, "matrix_http_request_duration_seconds_count", labels("route", route), count(cumulative))
+	}
+
+	counters := []struct {
+		name, help, label string
+		values            map[string]uint64
+	}{
+		{"matrix_http_request_bytes_total", "Bytes of the request bodies read, by route.", "route", m.bytesIn},
+		{"matrix_http_response_bytes_total", "Bytes of the responses written, by route.", "route", m.bytesOut},
+		{"matrix_rows_processed_total", "Rows of the inputs read by the operations, by operation.", "operation", m.rows},
+		{"matrix_temp_bytes_written_total", "Bytes spilled into temporary files by the transposes, by operation.", "operation", m.spilled},
+	}
+	for _, counter := range counters {
+		header(&b, counter.name, "counter", counter.help)
+		for _, key := range sortedKeys(counter.values) {
+			sample(&b, counter.name, labels(counter.label, key), count(counter.values[key]))
+		}
+	}
+
+	header(&b, "matrix_operations_in_flight", "gauge", "Operations running, of the requests and of the jobs.")
+	sample(&b, "matrix_operations_in_flight", "", strconv.FormatInt(m.inFlight.Load(), 10))
+	header(&b, "matrix_timeouts_total", "counter", "Operations stopped by the process timeout.")
+	sample(&b, "matrix_timeouts_total", "", count(m.timeouts.Load()))
+	return b.String()
+}
+
+func header(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sample(b *strings.Builder, name, labels, value string) {
+	fmt.Fprintf(b, "%s%s %s\n", name, labels, value)
+}
+
+// labelEscaper escapes the label values of the text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats pairs of label names and values as {name="value",...}
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+`="`+labelEscaper.Replace(pairs[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func count(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/league/BackendChallenge/matrix"
+)
+
+// media type of the error responses, RFC 9457
+const mimeProblemJSON = "application/problem+json"
+
+// codes of the errors raised by the handlers, the errors of the matrix
+// package keep their own codes such as NOT_SQUARE or RAGGED_ROW
+const (
+	codeEmptyFile        = "EMPTY_FILE"
+	codeUnsupportedType  = "UNSUPPORTED_TYPE"
+	codeTimeout          = "TIMEOUT"
+	codeInvalidParameter = "INVALID_PARAMETER"
+	codeBadRequest       = "BAD_REQUEST"
+	codeNotFound         = "NOT_FOUND"
+	codeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	codeNotAcceptable    = "NOT_ACCEPTABLE"
+	codeInternal         = "INTERNAL_ERROR"
+	codeJobNotFinished   = "JOB_NOT_FINISHED"
+	codeJobCancelled     = "JOB_CANCELLED"
+	codeUploadTooLarge   = "UPLOAD_TOO_LARGE"
+)
+
+// problem is the body of every error response: the problem details of RFC
+// 9457 extended with a stable code and the location of the offending cell
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code"`
+	Row    int    `json:"row,omitempty"`
+	Column int    `json:"column,omitempty"`
+	Token  string `json:"token,omitempty"`
+}
+
+func newProblem(status int, code, format string, args ...any) *problem {
+	return &problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: fmt.Sprintf(format, args...),
+		Code:   code,
+	}
+}
+
+func (p *problem) Error() string {
+	return fmt.Sprintf("code=%d, %s: %s", p.Status, p.Code, p.Detail)
+}
+
+// codes of the errors raised by echo itself, by status
+var statusCodes = map[int]string{
+	http.StatusNotFound:              codeNotFound,
+	http.StatusMethodNotAllowed:      codeMethodNotAllowed,
+	http.StatusNotAcceptable:         codeNotAcceptable,
+	http.StatusUnsupportedMediaType:  codeUnsupportedType,
+	http.StatusRequestEntityTooLarge: codeUploadTooLarge,
+	http.StatusGatewayTimeout:        codeTimeout,
+	http.StatusInternalServerError:   codeInternal,
+}
+
+// problemOf describes any error returned by a handler as a problem
+func problemOf(err error) *problem {
+	var p *problem
+	if errors.As(err, &p) {
+		return p
+	}
+
+	var me *matrix.Error
+	if errors.As(err, &me) {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, matrix.ErrTooLarge):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, matrix.ErrSingular), errors.Is(err, matrix.ErrResultTooLarge):
+			// the matrix is well formed but the operation is not defined for
+			// it, or its result would be too large
+			status = http.StatusUnprocessableEntity
+		}
+		p = newProblem(status, string(me.Code), "%s", me.Error())
+		p.Row, p.Column, p.Token = me.Row, me.Col, me.Token
+		return p
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		code, ok := statusCodes[he.Code]
+		if !ok {
+			code = codeBadRequest
+		}
+		return newProblem(he.Code, code, "%v", he.Message)
+	}
+	return newProblem(http.StatusInternalServerError, codeInternal, "%s", http.StatusText(http.StatusInternalServerError))
+}
+
+// problemHandler answers the errors returned by the handlers as
+// application/problem+json
+func problemHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		// the result is already being streamed, the error can only be logged
+		logger.Errorf("error after the response started: %v", err)
+		return
+	}
+
+	p := problemOf(err)
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(p.Status)
+	} else {
+		// drop the headers set for the result
+		c.Response().Header().Del(echo.HeaderContentEncoding)
+		c.Response().Header().Del("Trailer")
+		c.Response().Header().Del(headerETag)
+		c.Response().Header().Del(headerCache)
+		c.Response().Header().Set(echo.HeaderContentType, mimeProblemJSON)
+		err = c.JSON(p.Status, p)
+	}
+	if err != nil {
+		logger.Errorf("fail to send the error response: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/league/BackendChallenge/matrix"
+)
+
+// trailer telling how a streamed result ended: OK, or the code of the error
+// that cut it short
+const headerMatrixStatus = "X-Matrix-Status"
+
+// inputs up to this size are validated before the response is started,
+// larger ones and bodies of unknown length are streamed unless ?validate=true
+var validateSize int64 = 32 * 1024 * 1024 // 32MB
+
+// validateFirst reports whether the result is held back until the whole input
+// is processed, from ?validate= or else from the size of the input
+func validateFirst(c echo.Context, size int64) (bool, error) {
+	if value := c.QueryParam("validate"); value != "" {
+		validate, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, newProblem(http.StatusBadRequest, codeInvalidParameter, "invalid validate %q, expected true or false", value)
+		}
+		return validate, nil
+	}
+	return size >= 0 && size <= validateSize, nil
+}
+
+// writeResult runs op and sends the result it writes to the client.
+//
+// When the input is validated first, the result is spooled into a temporary
+// file and only sent once op succeeded, so any error is answered with its own
+// status. Otherwise it is streamed, rows are flushed as they are computed and
+// the status can no longer change: an error found midway ends the body with an
+// error marker, and the X-Matrix-Status trailer tells how the result ended.
+func writeResult(c echo.Context, format matrix.Format, size int64, op func(w io.Writer) error) error {
+	validate, err := validateFirst(c, size)
+	if err != nil {
+		return err
+	}
+	if validate {
+		return writeValidated(c, format, op)
+	}
+
+	resp := streamResponse(c, format)
+	resp.Header().Set("Trailer", headerMatrixStatus)
+	body := &lineWriter{Response: resp, lineStart: true}
+	if err = op(body); err == nil {
+		resp.Header().Set(headerMatrixStatus, "OK")
+		return nil
+	}
+	if err = operationError(err); err == nil || !resp.Committed {
+		// nothing is sent yet, the error gets its own status
+		return err
+	}
+
+	p := problemOf(err)
+	resp.Header().Set(headerMatrixStatus, p.Code)
+	if err = writeErrorMarker(body, format, p, body.lineStart); err != nil {
+		logger.Errorf("fail to write the error marker: %v", err)
+	}
+	resp.Flush()
+	return nil
+}
+
+// writeValidated spools the result of op and sends it once op succeeded
+func writeValidated(c echo.Context, format matrix.Format, op func(w io.Writer) error) error {
+	spool, err := os.CreateTemp(tempDir, "matrix_result_*.tmp")
+	if err != nil {
+		return newProblem(http.StatusInternalServerError, codeInternal, "fail to create temp file: %v", err)
+	}
+	defer func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}()
+
+	if err = op(spool); err != nil {
+		return operationError(err)
+	}
+	if _, err = spool.Seek(0, io.SeekStart); err != nil {
+		return newProblem(http.StatusInternalServerError, codeInternal, "fail to read the result: %v", err)
+	}
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, format.ContentType())
+	resp.WriteHeader(http.StatusOK)
+	_, err = io.Copy(resp, spool)
+	return err
+}
+
+// writeErrorMarker ends a streamed result cut short by an error with a last
+// line holding the problem: {"error": ...} in the JSON formats, or the problem
+// after #error in the others. The rows are written whole, but a row larger
+// than the write buffer may be cut, the marker then starts a new line.
+func writeErrorMarker(w io.Writer, format matrix.Format, p *problem, lineStart bool) error {
+	newLine := "\n"
+	if lineStart {
+		newLine = ""
+	}
+	if format == matrix.JSON || format == matrix.NDJSON {
+		marker, err := json.Marshal(map[string]*problem{"error": p})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s%s\n", newLine, marker)
+		return err
+	}
+	marker, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s#error %s\n", newLine, marker)
+	return err
+}
+
+// lineWriter is a response telling whether the bytes sent so far end a line
+type lineWriter struct {
+	*echo.Response
+	lineStart bool
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	n, err := w.Response.Write(p)
+	if n > 0 {
+		w.lineStart = p[n-1] == '\n'
+	}
+	return n, err
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// jobs runs the operations submitted to /jobs
+var jobs *jobStore
+
+// tempPatterns match the temporary files and directories the operations and
+// the handlers create in tempDir, and remove once done
+var tempPatterns = []string{
+	"matrix_invert*",
+	"matrix_product*",
+	"invert_*.tmp",
+	"matrix_result_*.tmp",
+	"matrix_mtx_*.tmp",
+}
+
+// requests being handled, waited for on shutdown
+var inFlight sync.WaitGroup
+
+// trackRequests counts the requests in flight
+func trackRequests(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		inFlight.Add(1)
+		defer inFlight.Done()
+		return next(c)
+	}
+}
+
+// serve runs the server on addr until ctx is done, then shuts it down: it
+// stops accepting connections and gives the requests and the jobs in flight
+// shutdownTimeout to finish, then cancels the others through their contexts
+// and waits for them to return
+func serve(ctx context.Context, e *echo.Echo, addr string) error {
+	base, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	e.Server.BaseContext = func(net.Listener) context.Context { return base }
+
+	errs := make(chan error, 1)
+	go func() { errs <- e.Start(addr) }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Infof("shutting down, waiting up to %s for the requests and jobs in flight", shutdownTimeout)
+	grace, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var stopped sync.WaitGroup
+	stopped.Add(1)
+	go func() {
+		defer stopped.Done()
+		jobs.shutdown(grace)
+	}()
+	if err := e.Shutdown(grace); err != nil {
+		logger.Warnf("cancelling the requests still running")
+		cancelRequests()
+		e.Close() // unblocks the handlers reading an upload
+	}
+	inFlight.Wait()
+	stopped.Wait()
+
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// sweepTempDir removes the temporary files and directories left in dir by the
+// operations of a previous run that did not finish, it returns the no of
+// entries removed. dir must not be shared with another running server.
+func sweepTempDir(dir string) int {
+	removed := 0
+	for _, pattern := range tempPatterns {
+		paths, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			continue
+		}
+		for _, path := range paths {
+			if err = os.RemoveAll(path); err != nil {
+				logger.Errorf("fail to remove %s: %v", path, err)
+				continue
+			}
+			removed++
+		}
+	}
+	if removed > 0 {
+		logger.Infof("removed %d temporary files left in %s", removed, dir)
+	}
+	return removed
+}